@@ -0,0 +1,39 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Account asks the server's login endpoint at loginURL whose account apiKey belongs to. That's who the
+// server seats the bot as, so it's the id the agent has to look for in game updates.
+func Account(loginURL, apiKey string) (string, error) {
+	if apiKey == "" {
+		return "", fmt.Errorf("No API Key")
+	}
+	req, err := http.NewRequest(http.MethodGet, loginURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("Can't find the API key's account: %s", strings.TrimSpace(string(body)))
+	}
+	account := &struct {
+		Id string
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(account)
+	if err != nil {
+		return "", err
+	}
+	return account.Id, nil
+}
@@ -0,0 +1,24 @@
+package ai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "Not logged in", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"Id": "bot"}`))
+	}))
+	defer server.Close()
+	if id, err := Account(server.URL, "key"); err != nil || id != "bot" {
+		t.Errorf("expected the key to belong to bot, got %q (%v)", id, err)
+	}
+	if _, err := Account(server.URL, "wrong"); err == nil {
+		t.Error("expected an unknown key to be an error")
+	}
+}
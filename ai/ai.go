@@ -4,6 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
+	"net/http"
+	"time"
+	"websockets/gameroom"
+)
+
+const (
+	maxReconnects = 5
+	reconnectWait = 2 * time.Second
 )
 
 type State interface {
@@ -21,20 +29,40 @@ type Agent interface {
 	BaseState() State
 }
 
+// Negotiator is an Agent that answers its opponent's offers, such as a draw offer, whoever's turn it is.
+// Respond sees every state before CanAct and returns nil when there's nothing to answer. A state that was
+// answered isn't acted on, since the game sends a new one once it has the answer.
+type Negotiator interface {
+	Respond(State) Action
+}
+
 type AI struct {
 	websocketURL    string
+	apiKey          string
+	session         string
 	state           State
 	stateUpdateChan chan State
 	actionSendChan  chan Action
 	agent           Agent
 	conn            *websocket.Conn
+	readErr         error
+	done            chan bool
 }
 
-func NewAgent(agent Agent, websocketURL string) (*AI, error) {
+// APIKeyEnv is where the bots read the API key their account was registered with.
+const APIKeyEnv = "GAMEROOM_API_KEY"
+
+// NewAgent plays agent in the game at websocketURL, authenticating with apiKey.
+func NewAgent(agent Agent, websocketURL, apiKey string) (*AI, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("No API Key")
+	}
 	toret := &AI{
 		websocketURL: websocketURL,
+		apiKey:       apiKey,
 		agent:        agent,
 		state:        agent.BaseState(),
+		done:         make(chan bool),
 	}
 	return toret, nil
 }
@@ -42,41 +70,49 @@ func NewAgent(agent Agent, websocketURL string) (*AI, error) {
 func (ai *AI) startActionWriteLoop() {
 	actionChan := make(chan Action, 8)
 	ai.actionSendChan = actionChan
+	conn := ai.conn
 	go func() {
-		for action := range ai.actionSendChan {
+		for action := range actionChan {
 			actionJson, err := action.MarshalJSON()
 			if err != nil {
 				fmt.Println("WARNING:", err.Error())
 			}
-			err = ai.conn.WriteMessage(websocket.TextMessage, actionJson)
+			err = conn.WriteMessage(websocket.TextMessage, actionJson)
 			if err != nil {
-				// Can't write, connection is probably closed
-				return
+				// Can't write, connection is probably closed. Drain until the read loop notices.
+				continue
 			}
 		}
 	}()
 }
 
+// Close stops the agent for good; Run returns instead of reconnecting.
 func (ai *AI) Close() {
-	close(ai.stateUpdateChan)
-	close(ai.actionSendChan)
+	close(ai.done)
 	ai.conn.Close()
 }
 
 func (ai *AI) startStateReadLoop() {
 	stateChan := make(chan State, 8)
 	ai.stateUpdateChan = stateChan
+	conn := ai.conn
 	go func() {
+		defer close(stateChan)
 		for {
-			mtype, msg, err := ai.conn.ReadMessage()
+			mtype, msg, err := conn.ReadMessage()
 			if err != nil {
-				ai.Close()
+				ai.readErr = err
 				return
 			}
 			switch mtype {
 			case websocket.TextMessage:
+				errMsg := &gameroom.ErrorMessage{}
+				if json.Unmarshal(msg, errMsg) == nil && errMsg.Error != "" {
+					fmt.Println("SERVER ERROR:", errMsg.Error)
+					continue
+				}
 				ai.state.UnmarshalJSON(msg)
-				ai.stateUpdateChan <- ai.state
+				stateChan <- ai.state
 			default:
 				continue
 			}
@@ -84,15 +120,41 @@ func (ai *AI) startStateReadLoop() {
 	}()
 }
 
-func (ai *AI) Run() error {
-	ws, _, err := websocket.DefaultDialer.Dial(ai.websocketURL, nil)
+// connect dials the game with our API key, presenting the session token from an earlier connection so the
+// server resumes our seat.
+func (ai *AI) connect() error {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+ai.apiKey)
+	if ai.session != "" {
+		cookie := &http.Cookie{Name: gameroom.SessionCookie, Value: ai.session}
+		header.Set("Cookie", cookie.String())
+	}
+	ws, resp, err := websocket.DefaultDialer.Dial(ai.websocketURL, header)
 	if err != nil {
 		return err
 	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == gameroom.SessionCookie {
+			ai.session = cookie.Value
+		}
+	}
 	ai.conn = ws
+	return nil
+}
+
+// play runs one connection until it drops, returning the error that ended it.
+func (ai *AI) play() error {
 	ai.startActionWriteLoop()
 	ai.startStateReadLoop()
+	negotiator, negotiates := ai.agent.(Negotiator)
 	for state := range ai.stateUpdateChan {
+		if negotiates {
+			if response := negotiator.Respond(state); response != nil {
+				fmt.Println("AGENT RESPONDED")
+				ai.actionSendChan <- response
+				continue
+			}
+		}
 		if ai.agent.CanAct(state) {
 			fmt.Println("AGENT CAN ACT")
 			action := ai.agent.GenerateAction(state)
@@ -101,5 +163,37 @@ func (ai *AI) Run() error {
 			fmt.Println("AGENT CANNOT ACT")
 		}
 	}
-	return nil
+	close(ai.actionSendChan)
+	ai.conn.Close()
+	return ai.readErr
+}
+
+func (ai *AI) Run() error {
+	reconnects := 0
+	for {
+		err := ai.connect()
+		if err != nil {
+			if reconnects >= maxReconnects {
+				return err
+			}
+			reconnects += 1
+			time.Sleep(reconnectWait)
+			continue
+		}
+		reconnects = 0
+		err = ai.play()
+		select {
+		case <-ai.done:
+			return nil
+		default:
+		}
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			return nil
+		}
+		if _, ok := err.(*websocket.CloseError); ok && !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			// The server closed us deliberately, e.g. superseded or rejected; retrying won't help.
+			return err
+		}
+		fmt.Println("CONNECTION LOST, RECONNECTING:", err)
+	}
 }
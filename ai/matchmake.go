@@ -0,0 +1,48 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"websockets/gameroom"
+	"websockets/matchmaking"
+)
+
+// Matchmake queues for a game at the server's /matchmake endpoint and waits to be matched, returning the id
+// of the room a seat is reserved in.
+func Matchmake(matchmakeURL, apiKey string, req *matchmaking.Request) (string, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+apiKey)
+	conn, _, err := websocket.DefaultDialer.Dial(matchmakeURL, header)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	err = conn.WriteJSON(req)
+	if err != nil {
+		return "", err
+	}
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return "", err
+		}
+		errMsg := &gameroom.ErrorMessage{}
+		if json.Unmarshal(msg, errMsg) == nil && errMsg.Error != "" {
+			return "", fmt.Errorf("%s", errMsg.Error)
+		}
+		status := &matchmaking.Status{}
+		err = json.Unmarshal(msg, status)
+		if err != nil {
+			return "", err
+		}
+		switch status.Status {
+		case matchmaking.StatusQueued:
+			fmt.Println("QUEUED FOR " + status.Game)
+		case matchmaking.StatusMatched:
+			fmt.Println("MATCHED WITH " + status.Opponent + " IN ROOM " + status.RoomId)
+			return status.RoomId, nil
+		}
+	}
+}
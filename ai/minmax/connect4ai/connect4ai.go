@@ -3,6 +3,7 @@ package connect4ai
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 	"websockets/ai"
 	ctypes "websockets/games/connect4/types"
 	internalstate "websockets/games/connect4/types/internalstate"
@@ -12,49 +13,48 @@ type State struct {
 	state *ctypes.UpdateGameState
 }
 
-func score(is *internalstate.InternalState, depth int) int {
+func score(is internalstate.Engine, depth int) int {
 	victor := is.VictoryCheck()
 	if victor < 0 {
 		if is.StalemateCheck() {
 			return -100
 		}
-		return evaluate(is)
+		return is.Evaluate()
 	}
-	if victor == is.Agent {
+	if victor == is.AgentColor() {
 		return 1000 + depth
 	}
 	return -1000 - depth
 }
 
-func evaluate(is *internalstate.InternalState) int {
-	total := 0
-	for col, h := range is.Height {
-		for row := 0; row < h; row += 1 {
-			score := is.LocScore[col][row]
-			piece := is.Board[col][row]
-			if piece == is.Agent {
-				total += score
-			} else if piece != -1 {
-				total -= score
-			}
-		}
-	}
-	return total
-}
+type Action ctypes.MoveData
 
-type Action struct {
-	Col     int
-	Rematch bool
-}
+// MaxDepth bounds iterative deepening under the Pop Out rules, where games have no fixed length.
+const MaxDepth = 64
+
+// DefaultBudget is how long an agent thinks about each move in untimed games.
+const DefaultBudget = time.Second
 
+// Agent searches deeper and deeper until its time for the move is up, and plays the best move from the
+// last depth it finished. In timed games the time comes from its clock; otherwise it's Budget.
 type Agent struct {
 	AgentId     string
 	RematchSent bool
+	Budget      time.Duration
+	deadline    internalstate.Deadline
+}
+
+func NewAgent(id string) *Agent {
+	return &Agent{
+		AgentId: id,
+		Budget:  DefaultBudget,
+		// Nodes are cheap, so the clock is only read every so many.
+		deadline: internalstate.Deadline{Every: 1024},
+	}
 }
 
 func (action *Action) MarshalJSON() ([]byte, error) {
-	tom := map[string]interface{}{"Col": action.Col, "Rematch": action.Rematch}
-	return json.Marshal(tom)
+	return json.Marshal(ctypes.MoveData(*action))
 }
 
 func (state *State) UnmarshalJSON(stateJson []byte) error {
@@ -70,17 +70,21 @@ func (state *State) LegalActions() []ai.Action {
 		return toret
 	}
 	for i, col := range state.state.Columns {
-		if len(col) < ctypes.Height {
+		if len(col) < state.state.Height {
 			toret = append(toret, &Action{
 				Col: i,
 			})
 		}
 	}
-	if len(toret) == 0 {
-		toret = append(toret, &Action{
-			Rematch: true,
-		})
-		return toret
+	if state.state.PopOut {
+		for i, col := range state.state.Columns {
+			if len(col) > 0 && col[0] == state.state.CurrentTurn {
+				toret = append(toret, &Action{
+					Col: i,
+					Pop: true,
+				})
+			}
+		}
 	}
 	return toret
 }
@@ -98,7 +102,10 @@ func (agent *Agent) CanAct(state ai.State) bool {
 	return correctTurn || rematch
 }
 
-func (agent *Agent) min(is *internalstate.InternalState, alpha, beta, p_action, depth int) (int, int) {
+func (agent *Agent) min(is internalstate.Engine, alpha, beta, p_action, depth int) (int, int) {
+	if agent.deadline.TimeUp() {
+		return p_action, 0
+	}
 	if depth == 0 || is.StalemateCheck() || is.VictoryCheck() >= 0 {
 		return p_action, score(is, depth)
 	}
@@ -125,7 +132,10 @@ func (agent *Agent) min(is *internalstate.InternalState, alpha, beta, p_action,
 	return bestAction, bestScore
 }
 
-func (agent *Agent) max(is *internalstate.InternalState, alpha, beta, p_action, depth int) (int, int) {
+func (agent *Agent) max(is internalstate.Engine, alpha, beta, p_action, depth int) (int, int) {
+	if agent.deadline.TimeUp() {
+		return p_action, 0
+	}
 	if depth == 0 || is.StalemateCheck() || is.VictoryCheck() >= 0 {
 		return p_action, score(is, depth)
 	}
@@ -154,17 +164,49 @@ func (agent *Agent) max(is *internalstate.InternalState, alpha, beta, p_action,
 
 func (agent *Agent) GenerateAction(state ai.State) ai.Action {
 	s := state.(*State)
-	is := internalstate.NewInternalState(agent.AgentId, s.state)
+	is := internalstate.NewEngine(agent.AgentId, s.state)
 	actions := state.LegalActions()
 	a := actions[0].(*Action)
 	agent.RematchSent = a.Rematch
 	if a.Rematch {
 		return a
 	}
-	action, score := agent.max(is, -10000000, 10000000, 0, 9)
+	action, score := agent.deepen(is, internalstate.MoveBudget(s.state, agent.AgentId, agent.Budget), maxDepth(s.state))
 	fmt.Println("Action: ", action)
 	fmt.Println("Score: ", score)
 	return &Action{
-		Col: action,
+		Col: is.MoveColumn(action),
+		Pop: is.IsPop(action),
+	}
+}
+
+func (agent *Agent) deepen(is internalstate.Engine, budget time.Duration, maxDepth int) (int, int) {
+	return internalstate.Deepen(is, &agent.deadline, budget, maxDepth, agent.max, agent.min)
+}
+
+// maxDepth is as deep as searching s can usefully go: to the end of the game, if it has a fixed length.
+func maxDepth(s *ctypes.UpdateGameState) int {
+	if s.PopOut {
+		return MaxDepth
+	}
+	toret := s.Width * s.Height
+	for _, col := range s.Columns {
+		toret -= len(col)
+	}
+	return toret
+}
+
+// Respond answers draw offers, accepting unless a search says we're ahead. The search gets the same budget
+// as a move would, so answering doesn't cost the game on time.
+func (agent *Agent) Respond(state ai.State) ai.Action {
+	s := state.(*State)
+	if !s.state.DrawOfferedTo(agent.AgentId) {
+		return nil
+	}
+	is := internalstate.NewEngine(agent.AgentId, s.state)
+	_, score := agent.deepen(is, internalstate.MoveBudget(s.state, agent.AgentId, agent.Budget), maxDepth(s.state))
+	if score > 0 {
+		return &Action{DeclineDraw: true}
 	}
+	return &Action{AcceptDraw: true}
 }
@@ -0,0 +1,73 @@
+package connect4ai
+
+import (
+	"testing"
+	"time"
+	ctypes "websockets/games/connect4/types"
+	"websockets/games/connect4/types/internalstate"
+)
+
+func newState(notation string) *State {
+	s := &ctypes.UpdateGameState{
+		GameState: ctypes.NewGameState(ctypes.DefaultOptions()),
+		Players:   map[string]ctypes.Color{"agent": ctypes.Red},
+	}
+	moves, _ := ctypes.ParseNotation(notation)
+	for _, m := range moves {
+		s.Columns[m.Col] = append(s.Columns[m.Col], s.CurrentTurn)
+		s.CurrentTurn = ctypes.Black - s.CurrentTurn
+	}
+	return &State{state: s}
+}
+
+func TestFindsWin(t *testing.T) {
+	// Red has the first three along the bottom, with black's pieces on top of them.
+	agent := NewAgent("agent")
+	action := agent.GenerateAction(newState("112233")).(*Action)
+	if action.Col != 3 || action.Pop {
+		t.Errorf("expected red to complete the row, got %+v", action)
+	}
+}
+
+func TestDeepenKeepsToBudget(t *testing.T) {
+	agent := NewAgent("agent")
+	state := newState("")
+	is := internalstate.NewEngine("agent", state.state)
+	start := time.Now()
+	action, _ := agent.deepen(is, 100*time.Millisecond, maxDepth(state.state))
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("searched for %v on a 100ms budget", elapsed)
+	}
+	if action < 0 || action >= ctypes.DefaultWidth || is.Ply() != 0 {
+		t.Errorf("expected a move from the empty board with it left empty, got %d after %d moves", action, is.Ply())
+	}
+	if agent.deadline.TimeUp() {
+		t.Error("a search that ran out of time shouldn't stop the next one")
+	}
+}
+
+func TestRespond(t *testing.T) {
+	agent := NewAgent("agent")
+	agent.Budget = 100 * time.Millisecond
+	// Black offers a draw straight after red's first move, in a position too open to search to the end.
+	state := newState("4")
+	state.state.DrawOffer = "opponent"
+	start := time.Now()
+	agent.Respond(state)
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("answered a draw offer in %v on a 100ms budget", elapsed)
+	}
+
+	// Red has three in the middle of the bottom row and wins whichever end black blocks.
+	state = newState("22334")
+	state.state.DrawOffer = "opponent"
+	if action := agent.Respond(state).(*Action); !action.DeclineDraw {
+		t.Errorf("expected red to decline a draw it's winning, got %+v", action)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	if depth := maxDepth(newState("4444").state); depth != 38 {
+		t.Errorf("expected to search at most the 38 empty squares, got %d", depth)
+	}
+}
@@ -3,7 +3,10 @@ package connect4ai
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
+	"runtime"
+	"sync"
 	"time"
 
 	"websockets/ai"
@@ -19,163 +22,337 @@ func (state *State) UnmarshalJSON(stateJson []byte) error {
 	return json.Unmarshal(stateJson, state.state)
 }
 
-type Action struct {
-	Col     int
-	Rematch bool
-}
+type Action ctypes.MoveData
 
 func (action *Action) MarshalJSON() ([]byte, error) {
-	tom := map[string]interface{}{"Col": action.Col, "Rematch": action.Rematch}
-	return json.Marshal(tom)
+	return json.Marshal(ctypes.MoveData(*action))
 }
 
+// DefaultExploration is the UCB1 exploration constant NewAgent starts with: the square root of 2 that
+// suits results between 0 and 1.
+const DefaultExploration = math.Sqrt2
+
+// DefaultBudget is how long NewAgent's agents search each move in untimed games.
+const DefaultBudget = time.Second
+
+// Parallelism is how an Agent spreads its search over several goroutines.
+type Parallelism int
+
+const (
+	// RootParallel gives each worker a tree of its own, and plays the move with the most playouts across
+	// all of them. Workers never wait on each other.
+	RootParallel Parallelism = iota
+	// TreeParallel has the workers share one deeper tree under a lock, taking it only to walk and update
+	// the tree, not for playouts. A worker adds a virtual loss to every node on its way down, which the
+	// result replaces, so the others look elsewhere until it's done.
+	TreeParallel
+)
+
+// Agent plays by Monte Carlo tree search with UCT. Exploration weighs trying moves with few playouts
+// against playing the ones that have done best so far. The tree under the move the agent plays is kept,
+// and its search picks up from the subtree for whatever the opponent replies.
+//
+// Workers goroutines search at once, as Parallelism says. Each is seeded from the agent's own source, so
+// Seed makes a RootParallel search reproducible when Playouts, rather than the clock, limits it.
+//
+// In timed games the time for a move comes from the agent's clock; otherwise it's Budget.
 type Agent struct {
-	Nodes       map[string]*Node
 	AgentId     string
 	RematchSent bool
+	Exploration float64
+	Budget      time.Duration
+	Workers     int
+	Parallelism Parallelism
+	// Playouts, if positive, stops each worker after that many playouts, however much time is left.
+	Playouts int
+	root     *Node
+	random   *rand.Rand
 }
 
 func NewAgent(id string) *Agent {
 	return &Agent{
-		AgentId: id,
-		Nodes:   map[string]*Node{},
+		AgentId:     id,
+		Exploration: DefaultExploration,
+		Budget:      DefaultBudget,
+		Workers:     runtime.NumCPU(),
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
+// Seed resets the source the workers' seeds are drawn from.
+func (agent *Agent) Seed(seed int64) {
+	agent.random = rand.New(rand.NewSource(seed))
+}
+
+// Node is a position in the search tree, reached by move. wins counts the playouts through the node won
+// by color, the player who made move, with draws as half a win; visits counts them all. untried holds the
+// moves that don't have children yet, and is empty once the game is over.
 type Node struct {
 	color    int
 	move     int
-	wins     int
-	total    int
-	children map[int]*Node
+	key      uint64
+	wins     float64
+	visits   int
+	untried  []int
+	children []*Node
 	parent   *Node
 }
 
-func (agent *Agent) Selection(node *Node, is *internalstate.InternalState) (*Node, int) {
-	current := node
-	for {
-		moves := is.GenerateMoves()
-		if len(moves) == 0 {
-			return nil, -1
-		}
-		action := moves[rand.Intn(len(moves))]
-		is.MakeMove(action)
-		if child, ok := current.children[action]; ok {
-			current = child
-		} else {
-			return current, action
+func newNode(parent *Node, move int, is internalstate.Engine) *Node {
+	toret := &Node{
+		color:  1 - is.ToMove(),
+		move:   move,
+		key:    is.Key(),
+		parent: parent,
+	}
+	if is.VictoryCheck() < 0 && !is.StalemateCheck() {
+		toret.untried = is.GenerateMoves()
+	}
+	return toret
+}
+
+// ucb1 scores node for selection: its win rate, plus a bonus that shrinks the more it's visited relative
+// to its parent.
+func (node *Node) ucb1(exploration, logParentVisits float64) float64 {
+	visits := float64(node.visits)
+	return node.wins/visits + exploration*math.Sqrt(logParentVisits/visits)
+}
+
+func (node *Node) selectChild(exploration float64) *Node {
+	logVisits := math.Log(float64(node.visits))
+	toret := node.children[0]
+	best := toret.ucb1(exploration, logVisits)
+	for _, child := range node.children[1:] {
+		if score := child.ucb1(exploration, logVisits); score > best {
+			toret = child
+			best = score
 		}
 	}
-	return nil, -1
+	return toret
 }
 
-func (agent *Agent) Expand(node *Node, is *internalstate.InternalState, action int) (string, *Node) {
-	child_state := is.ToString()
-	child := &Node{
-		color:    1 - is.Turn,
-		move:     action,
-		children: map[int]*Node{},
-		parent:   node,
+// Selection follows UCB1 down from node, making each move on is, until it reaches a node with moves left
+// to try or a finished game.
+func (agent *Agent) Selection(node *Node, is internalstate.Engine) *Node {
+	current := node
+	for len(current.untried) == 0 && len(current.children) > 0 {
+		current = current.selectChild(agent.Exploration)
+		is.MakeMove(current.move)
 	}
-	node.children[action] = child
-	return child_state, child
+	return current
 }
 
-func (agent *Agent) Simulation(node *Node, is *internalstate.InternalState) int {
+// Expand adds a child for one of node's untried moves, chosen at random, and makes that move on is. A
+// node for a finished game has nothing to expand and is returned as it is.
+func (agent *Agent) Expand(node *Node, is internalstate.Engine, random *rand.Rand) *Node {
+	if len(node.untried) == 0 {
+		return node
+	}
+	i := random.Intn(len(node.untried))
+	move := node.untried[i]
+	node.untried[i] = node.untried[len(node.untried)-1]
+	node.untried = node.untried[:len(node.untried)-1]
+	is.MakeMove(move)
+	child := newNode(node, move, is)
+	node.children = append(node.children, child)
+	return child
+}
+
+// Simulation plays random moves to the end of the game, returning the winner, or -1 for a draw.
+func (agent *Agent) Simulation(is internalstate.Engine, random *rand.Rand) int {
 	for {
+		if victor := is.VictoryCheck(); victor >= 0 {
+			return victor
+		}
 		if is.StalemateCheck() {
 			return -1
-		} else {
-			vc := is.VictoryCheck()
-			if vc >= 0 {
-				return vc
-			}
 		}
 		legalMoves := is.GenerateMoves()
-		nextMove := legalMoves[rand.Intn(len(legalMoves))]
-		is.MakeMove(nextMove)
+		is.MakeMove(legalMoves[random.Intn(len(legalMoves))])
 	}
-	return -1
 }
 
 func (agent *Agent) Backpropagation(node *Node, winner int) {
 	for current := node; current != nil; current = current.parent {
-		current.total += 1
-		if int(current.color) == winner {
-			current.wins += 1
-		}
+		current.visits += 1
+		current.credit(winner)
 	}
 }
 
-func (agent *Agent) stateReset(moveCount int, is *internalstate.InternalState) {
-	for len(is.Moves) > moveCount {
+func (node *Node) credit(winner int) {
+	if node.color == winner {
+		node.wins += 1
+	} else if winner < 0 {
+		node.wins += 0.5
+	}
+}
+
+func (agent *Agent) stateReset(moveCount int, is internalstate.Engine) {
+	for is.Ply() > moveCount {
 		is.UnmakeMove()
 	}
 }
 
-func (agent *Agent) Search(current *Node, is *internalstate.InternalState) {
-	defer agent.stateReset(len(is.Moves), is)
+// Search runs one playout from root, leaving is as it found it.
+func (agent *Agent) Search(root *Node, is internalstate.Engine, random *rand.Rand) {
+	defer agent.stateReset(is.Ply(), is)
+	node := agent.Expand(agent.Selection(root, is), is, random)
+	agent.Backpropagation(node, agent.Simulation(is, random))
+}
 
-	node, action := agent.Selection(current, is)
-	if node == nil {
-		return
+// sharedSearch runs one playout from root in a tree other workers are searching too. The visit each node
+// on the path is given up front counts as a loss until the result comes in.
+func (agent *Agent) sharedSearch(root *Node, is internalstate.Engine, random *rand.Rand, mutex *sync.Mutex) {
+	defer agent.stateReset(is.Ply(), is)
+	mutex.Lock()
+	node := agent.Expand(agent.Selection(root, is), is, random)
+	for current := node; current != nil; current = current.parent {
+		current.visits += 1
 	}
+	mutex.Unlock()
 
-	key, child := agent.Expand(node, is, action)
-	agent.Nodes[key] = child
+	winner := agent.Simulation(is, random)
 
-	winner := agent.Simulation(child, is)
-
-	agent.Backpropagation(child, winner)
+	mutex.Lock()
+	for current := node; current != nil; current = current.parent {
+		current.credit(winner)
+	}
+	mutex.Unlock()
 }
 
-func (agent *Agent) RunSearch(duration time.Duration, is *internalstate.InternalState) int {
-	timer := time.After(duration)
-
-	current := agent.InitialNode(is)
-	if current == nil {
-		panic("INITIAL NODE RETURNED NIL")
-	}
-	cont := true
-	for cont {
+// worker runs search until done is closed, or it has run Playouts times.
+func (agent *Agent) worker(done <-chan struct{}, search func()) {
+	for playouts := 0; agent.Playouts <= 0 || playouts < agent.Playouts; playouts += 1 {
 		select {
-		case <-timer:
-			cont = false
+		case <-done:
+			return
 		default:
 		}
-		agent.Search(current, is)
+		search()
 	}
+}
+
+func (agent *Agent) workerCount() int {
+	if agent.Workers < 1 {
+		return 1
+	}
+	return agent.Workers
+}
+
+func (agent *Agent) workerRandoms() []*rand.Rand {
+	toret := []*rand.Rand{}
+	for i := 0; i < agent.workerCount(); i += 1 {
+		toret = append(toret, rand.New(rand.NewSource(agent.random.Int63())))
+	}
+	return toret
+}
 
-	best_ratio := 0.0
-	toret := -1
+// RunSearch searches from is for duration and returns the move with the most playouts, the robust child,
+// which the search trusts more than whichever has the best win rate. Its subtree is kept for the next
+// search.
+func (agent *Agent) RunSearch(duration time.Duration, is internalstate.Engine) int {
+	done := make(chan struct{})
+	timer := time.AfterFunc(duration, func() { close(done) })
+	defer timer.Stop()
 
-	for action, child := range current.children {
-		fmt.Printf("Child: %+v\n", *child)
-		win_ratio := float64(child.wins) / float64(child.total)
-		if win_ratio > best_ratio {
-			toret = action
-			best_ratio = win_ratio
+	root := agent.InitialNode(is)
+	reused := root.visits
+	var visits map[int]int
+	if agent.Parallelism == TreeParallel {
+		visits = agent.treeSearch(root, is, done)
+	} else {
+		visits = agent.rootSearch(root, is, done)
+	}
+
+	best, bestVisits, total := -1, 0, 0
+	for move, count := range visits {
+		total += count
+		if count > bestVisits || (count == bestVisits && move < best) {
+			best, bestVisits = move, count
+		}
+	}
+	fmt.Printf("Searched %d playouts on %d workers, %d of them reused\n", total, agent.workerCount(), reused)
+	for _, child := range root.children {
+		fmt.Printf("Move %d: %d visits, %.3f\n", child.move, child.visits, child.wins/float64(child.visits))
+	}
+	agent.root = nil
+	for _, child := range root.children {
+		if child.move == best {
+			child.parent = nil
+			agent.root = child
 		}
 	}
-	if toret < 0 {
+	if best < 0 {
 		moves := is.GenerateMoves()
-		return moves[rand.Intn(len(moves))]
+		return moves[agent.random.Intn(len(moves))]
+	}
+	return best
+}
+
+// rootSearch has every worker search a tree of its own, the first continuing from root, and adds up their
+// visits to each move.
+func (agent *Agent) rootSearch(root *Node, is internalstate.Engine, done <-chan struct{}) map[int]int {
+	randoms := agent.workerRandoms()
+	roots := []*Node{root}
+	for len(roots) < len(randoms) {
+		roots = append(roots, newNode(nil, -1, is))
+	}
+	var wg sync.WaitGroup
+	for i := range randoms {
+		wg.Add(1)
+		go func(root *Node, is internalstate.Engine, random *rand.Rand) {
+			defer wg.Done()
+			agent.worker(done, func() { agent.Search(root, is, random) })
+		}(roots[i], is.Clone(), randoms[i])
+	}
+	wg.Wait()
+
+	toret := map[int]int{}
+	for _, root := range roots {
+		for _, child := range root.children {
+			toret[child.move] += child.visits
+		}
+	}
+	return toret
+}
+
+func (agent *Agent) treeSearch(root *Node, is internalstate.Engine, done <-chan struct{}) map[int]int {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, random := range agent.workerRandoms() {
+		wg.Add(1)
+		go func(is internalstate.Engine, random *rand.Rand) {
+			defer wg.Done()
+			agent.worker(done, func() { agent.sharedSearch(root, is, random, &mutex) })
+		}(is.Clone(), random)
+	}
+	wg.Wait()
+
+	toret := map[int]int{}
+	for _, child := range root.children {
+		toret[child.move] = child.visits
 	}
 	return toret
 }
 
-func (agent *Agent) InitialNode(is *internalstate.InternalState) *Node {
-	state_str := is.ToString()
-	if _, ok := agent.Nodes[state_str]; !ok {
-		agent.Nodes[state_str] = &Node{
-			children: map[int]*Node{},
-			color:    -1,
-			move:     -1,
-			wins:     -1,
-			total:    -1,
+// InitialNode is the root to search is from: the subtree kept from the last search if it reached this
+// position, which it will have if it's the opponent's reply to the agent's last move, or else a new tree.
+func (agent *Agent) InitialNode(is internalstate.Engine) *Node {
+	key := is.Key()
+	if agent.root != nil {
+		if agent.root.key == key {
+			return agent.root
+		}
+		for _, child := range agent.root.children {
+			if child.key == key {
+				child.parent = nil
+				agent.root = child
+				return child
+			}
 		}
 	}
-	return agent.Nodes[state_str]
+	agent.root = newNode(nil, -1, is)
+	return agent.root
 }
 
 func (state *State) LegalActions() []ai.Action {
@@ -187,17 +364,21 @@ func (state *State) LegalActions() []ai.Action {
 		return toret
 	}
 	for i, col := range state.state.Columns {
-		if len(col) < ctypes.Height {
+		if len(col) < state.state.Height {
 			toret = append(toret, &Action{
 				Col: i,
 			})
 		}
 	}
-	if len(toret) == 0 {
-		toret = append(toret, &Action{
-			Rematch: true,
-		})
-		return toret
+	if state.state.PopOut {
+		for i, col := range state.state.Columns {
+			if len(col) > 0 && col[0] == state.state.CurrentTurn {
+				toret = append(toret, &Action{
+					Col: i,
+					Pop: true,
+				})
+			}
+		}
 	}
 	return toret
 }
@@ -217,8 +398,7 @@ func (agent *Agent) CanAct(state ai.State) bool {
 
 func (agent *Agent) GenerateAction(state ai.State) ai.Action {
 	s := state.(*State)
-	is := internalstate.NewInternalState(agent.AgentId, s.state)
-	fresh := internalstate.NewInternalState(agent.AgentId, s.state)
+	is := internalstate.NewEngine(agent.AgentId, s.state)
 	actions := state.LegalActions()
 	a := actions[0].(*Action)
 	agent.RematchSent = a.Rematch
@@ -228,11 +408,10 @@ func (agent *Agent) GenerateAction(state ai.State) ai.Action {
 	if len(actions) == 1 {
 		return actions[0].(*Action)
 	}
-	action := agent.RunSearch(1000*time.Millisecond, is)
+	action := agent.RunSearch(internalstate.MoveBudget(s.state, agent.AgentId, agent.Budget), is)
 	fmt.Println("Action:", action)
-	fmt.Println(fresh.ToString())
-	fmt.Println(is.ToString())
 	return &Action{
-		Col: action,
+		Col: is.MoveColumn(action),
+		Pop: is.IsPop(action),
 	}
 }
@@ -0,0 +1,126 @@
+package connect4ai
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+	ctypes "websockets/games/connect4/types"
+	"websockets/games/connect4/types/internalstate"
+)
+
+func newEngine(notation string) internalstate.Engine {
+	s := &ctypes.UpdateGameState{
+		GameState: ctypes.NewGameState(ctypes.DefaultOptions()),
+		Players:   map[string]ctypes.Color{"agent": ctypes.Red},
+	}
+	is := internalstate.NewEngine("agent", s)
+	moves, _ := ctypes.ParseNotation(notation)
+	for _, m := range moves {
+		is.MakeMove(m.Col)
+	}
+	return is
+}
+
+func TestFindsWin(t *testing.T) {
+	for _, parallelism := range []Parallelism{RootParallel, TreeParallel} {
+		// Red has the first three along the bottom, with black's pieces on top of them.
+		is := newEngine("112233")
+		agent := NewAgent("agent")
+		agent.Workers = 4
+		agent.Parallelism = parallelism
+		if move := agent.RunSearch(200*time.Millisecond, is); move != 3 {
+			t.Errorf("parallelism %d: expected red to complete the row, got column %d", parallelism, move)
+		}
+		if is.Ply() != 6 {
+			t.Errorf("the search should leave the position as it found it, got %d moves", is.Ply())
+		}
+	}
+}
+
+func TestTreeParallelCountsEveryPlayout(t *testing.T) {
+	agent := NewAgent("agent")
+	agent.Workers = 4
+	agent.Parallelism = TreeParallel
+	agent.Playouts = 500
+	is := newEngine("44")
+	root := agent.InitialNode(is)
+	agent.RunSearch(time.Minute, is)
+	// Every virtual loss is replaced by a result, so the visits add up exactly.
+	visits, wins := 0, 0.0
+	for _, child := range root.children {
+		visits += child.visits
+		wins += child.wins
+	}
+	if root.visits != 2000 || visits != 2000 {
+		t.Errorf("expected 2000 playouts through the root and its children, got %d and %d", root.visits, visits)
+	}
+	if wins > 2000 {
+		t.Errorf("more wins than playouts: %v", wins)
+	}
+}
+
+func TestSeededRootSearchIsReproducible(t *testing.T) {
+	search := func() (int, map[int]int) {
+		agent := NewAgent("agent")
+		agent.Workers = 4
+		agent.Playouts = 300
+		agent.Seed(7)
+		move := agent.RunSearch(time.Minute, newEngine("4453"))
+		visits := map[int]int{}
+		for _, child := range agent.root.children {
+			visits[child.move] = child.visits
+		}
+		return move, visits
+	}
+	move, visits := search()
+	for i := 0; i < 3; i += 1 {
+		again, againVisits := search()
+		if again != move || !reflect.DeepEqual(againVisits, visits) {
+			t.Fatalf("seeded searches differ: %d %v and %d %v", move, visits, again, againVisits)
+		}
+	}
+}
+
+func TestReusesOpponentReply(t *testing.T) {
+	agent := NewAgent("agent")
+	is := newEngine("")
+	move := agent.RunSearch(100*time.Millisecond, is)
+	kept := agent.root
+	is.MakeMove(move)
+	reply := kept.children[0]
+	is.MakeMove(reply.move)
+	if root := agent.InitialNode(is); root != reply || root.parent != nil || root.visits == 0 {
+		t.Error("expected the search to carry on from the subtree for the opponent's reply")
+	}
+
+	is.UnmakeMove()
+	is.UnmakeMove()
+	if root := agent.InitialNode(is); root == reply || root.visits != 0 {
+		t.Error("expected a position the tree doesn't know to get a new root")
+	}
+}
+
+func benchmarkSearch(b *testing.B, workers int, parallelism Parallelism) {
+	agent := NewAgent("agent")
+	agent.Workers = workers
+	agent.Parallelism = parallelism
+	agent.Playouts = 2000
+	for i := 0; i < b.N; i += 1 {
+		agent.root = nil
+		agent.RunSearch(time.Minute, newEngine(""))
+	}
+	b.ReportMetric(float64(b.N*workers*agent.Playouts)/b.Elapsed().Seconds(), "playouts/s")
+}
+
+func BenchmarkSearchOneWorker(b *testing.B) {
+	benchmarkSearch(b, 1, RootParallel)
+}
+
+func BenchmarkSearchRootParallel(b *testing.B) {
+	benchmarkSearch(b, runtime.NumCPU(), RootParallel)
+}
+
+func BenchmarkSearchTreeParallel(b *testing.B) {
+	benchmarkSearch(b, runtime.NumCPU(), TreeParallel)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"time"
 	"websockets/ai"
 	ctypes "websockets/games/connect4/types"
 	internalstate "websockets/games/connect4/types/internalstate"
@@ -13,7 +14,7 @@ type State struct {
 	state *ctypes.UpdateGameState
 }
 
-func (agent *Agent) score(is *internalstate.InternalState, depth int) int {
+func (agent *Agent) score(is internalstate.Engine, depth int) int {
 	victor := is.VictoryCheck()
 	if victor < 0 {
 		if is.StalemateCheck() {
@@ -21,16 +22,16 @@ func (agent *Agent) score(is *internalstate.InternalState, depth int) int {
 		}
 		return agent.evaluate(is)
 	}
-	if victor == is.Agent {
+	if victor == is.AgentColor() {
 		return 1000 + depth
 	}
 	return -1000 - depth
 }
 
-func (agent *Agent) rollout(is *internalstate.InternalState) int {
+func (agent *Agent) rollout(is internalstate.Engine) int {
 	victor := is.VictoryCheck()
 	if victor > -1 {
-		if victor == is.Agent {
+		if victor == is.AgentColor() {
 			return 1
 		} else {
 			return 0
@@ -46,33 +47,50 @@ func (agent *Agent) rollout(is *internalstate.InternalState) int {
 	return agent.rollout(is)
 }
 
-func (agent *Agent) evaluate(is *internalstate.InternalState) int {
-	str := is.ToString()
-	if score, ok := agent.Visited[str]; ok {
+func (agent *Agent) evaluate(is internalstate.Engine) int {
+	key := is.Key()
+	if score, ok := agent.Visited[key]; ok {
 		return score
 	}
 	total := 0
 	for i := 0; i < 100; i += 1 {
 		total += agent.rollout(is)
 	}
-	agent.Visited[str] = total
+	agent.Visited[key] = total
 	return total
 }
 
-type Action struct {
-	Col     int
-	Rematch bool
-}
+type Action ctypes.MoveData
+
+// Depth is as deep as the agent searches. Every leaf is scored with rollouts, so going deeper rarely fits
+// in the time for a move.
+const Depth = 5
+
+// DefaultBudget is how long an agent thinks about each move in untimed games.
+const DefaultBudget = time.Second
 
+// Agent searches one move deeper at a time, up to Depth, and plays the best move from the last depth it
+// finished before its time for the move was up. In timed games the time comes from its clock; otherwise
+// it's Budget.
 type Agent struct {
 	AgentId     string
 	RematchSent bool
-	Visited     map[string]int
+	Visited     map[uint64]int
+	Budget      time.Duration
+	// Leaves are slow to score, so deadline reads the clock at every node.
+	deadline internalstate.Deadline
+}
+
+func NewAgent(id string) *Agent {
+	return &Agent{
+		AgentId: id,
+		Visited: map[uint64]int{},
+		Budget:  DefaultBudget,
+	}
 }
 
 func (action *Action) MarshalJSON() ([]byte, error) {
-	tom := map[string]interface{}{"Col": action.Col, "Rematch": action.Rematch}
-	return json.Marshal(tom)
+	return json.Marshal(ctypes.MoveData(*action))
 }
 
 func (state *State) UnmarshalJSON(stateJson []byte) error {
@@ -88,17 +106,21 @@ func (state *State) LegalActions() []ai.Action {
 		return toret
 	}
 	for i, col := range state.state.Columns {
-		if len(col) < ctypes.Height {
+		if len(col) < state.state.Height {
 			toret = append(toret, &Action{
 				Col: i,
 			})
 		}
 	}
-	if len(toret) == 0 {
-		toret = append(toret, &Action{
-			Rematch: true,
-		})
-		return toret
+	if state.state.PopOut {
+		for i, col := range state.state.Columns {
+			if len(col) > 0 && col[0] == state.state.CurrentTurn {
+				toret = append(toret, &Action{
+					Col: i,
+					Pop: true,
+				})
+			}
+		}
 	}
 	return toret
 }
@@ -116,7 +138,10 @@ func (agent *Agent) CanAct(state ai.State) bool {
 	return correctTurn || rematch
 }
 
-func (agent *Agent) min(is *internalstate.InternalState, alpha, beta, p_action, depth int) (int, int) {
+func (agent *Agent) min(is internalstate.Engine, alpha, beta, p_action, depth int) (int, int) {
+	if agent.deadline.TimeUp() {
+		return p_action, 0
+	}
 	if depth == 0 || is.StalemateCheck() || is.VictoryCheck() >= 0 {
 		return p_action, agent.score(is, depth)
 	}
@@ -143,7 +168,10 @@ func (agent *Agent) min(is *internalstate.InternalState, alpha, beta, p_action,
 	return bestAction, bestScore
 }
 
-func (agent *Agent) max(is *internalstate.InternalState, alpha, beta, p_action, depth int) (int, int) {
+func (agent *Agent) max(is internalstate.Engine, alpha, beta, p_action, depth int) (int, int) {
+	if agent.deadline.TimeUp() {
+		return p_action, 0
+	}
 	if depth == 0 || is.StalemateCheck() || is.VictoryCheck() >= 0 {
 		return p_action, agent.score(is, depth)
 	}
@@ -171,19 +199,41 @@ func (agent *Agent) max(is *internalstate.InternalState, alpha, beta, p_action,
 }
 
 func (agent *Agent) GenerateAction(state ai.State) ai.Action {
-	agent.Visited = map[string]int{}
 	s := state.(*State)
-	is := internalstate.NewInternalState(agent.AgentId, s.state)
+	is := internalstate.NewEngine(agent.AgentId, s.state)
 	actions := state.LegalActions()
 	a := actions[0].(*Action)
 	agent.RematchSent = a.Rematch
 	if a.Rematch {
 		return a
 	}
-	action, score := agent.max(is, -10000000, 10000000, 0, 5)
+	action, score := agent.deepen(is, internalstate.MoveBudget(s.state, agent.AgentId, agent.Budget))
 	fmt.Println("Action: ", action)
 	fmt.Println("Score: ", score)
 	return &Action{
-		Col: action,
+		Col: is.MoveColumn(action),
+		Pop: is.IsPop(action),
+	}
+}
+
+// deepen searches within budget, with rollout scores kept between depths so the shallower searches cost
+// little by the time the deepest one has run.
+func (agent *Agent) deepen(is internalstate.Engine, budget time.Duration) (int, int) {
+	agent.Visited = map[uint64]int{}
+	return internalstate.Deepen(is, &agent.deadline, budget, Depth, agent.max, agent.min)
+}
+
+// Respond answers a draw offer by searching the position within the time a move would get, declining if
+// the search scores it in the agent's favour and accepting otherwise.
+func (agent *Agent) Respond(state ai.State) ai.Action {
+	s := state.(*State)
+	if !s.state.DrawOfferedTo(agent.AgentId) {
+		return nil
+	}
+	is := internalstate.NewEngine(agent.AgentId, s.state)
+	_, score := agent.deepen(is, internalstate.MoveBudget(s.state, agent.AgentId, agent.Budget))
+	if score > 0 {
+		return &Action{DeclineDraw: true}
 	}
+	return &Action{AcceptDraw: true}
 }
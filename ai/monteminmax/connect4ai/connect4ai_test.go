@@ -0,0 +1,24 @@
+package connect4ai
+
+import (
+	"testing"
+	"time"
+	ctypes "websockets/games/connect4/types"
+)
+
+func TestGenerateActionKeepsToBudget(t *testing.T) {
+	agent := NewAgent("agent")
+	agent.Budget = 100 * time.Millisecond
+	state := &State{state: &ctypes.UpdateGameState{
+		GameState: ctypes.NewGameState(ctypes.DefaultOptions()),
+		Players:   map[string]ctypes.Color{"agent": ctypes.Red},
+	}}
+	start := time.Now()
+	action := agent.GenerateAction(state).(*Action)
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("searched for %v on a 100ms budget", elapsed)
+	}
+	if action.Col < 0 || action.Col >= ctypes.DefaultWidth || action.Pop {
+		t.Errorf("expected a move from the empty board, got %+v", action)
+	}
+}
@@ -5,17 +5,14 @@ import (
 	"fmt"
 	"math/rand"
 	"websockets/ai"
-	"websockets/games/connect4"
+	ctypes "websockets/games/connect4/types"
 )
 
 type State struct {
-	state *connect4.UpdateGameState
+	state *ctypes.UpdateGameState
 }
 
-type Action struct {
-	Col     int
-	Rematch bool
-}
+type Action ctypes.MoveData
 
 type Agent struct {
 	AgentId     string
@@ -23,8 +20,7 @@ type Agent struct {
 }
 
 func (action *Action) MarshalJSON() ([]byte, error) {
-	tom := map[string]interface{}{"Col": action.Col, "Rematch": action.Rematch}
-	return json.Marshal(tom)
+	return json.Marshal(ctypes.MoveData(*action))
 }
 
 func (state *State) UnmarshalJSON(stateJson []byte) error {
@@ -40,18 +36,28 @@ func (state *State) LegalActions() []ai.Action {
 		return toret
 	}
 	for i, col := range state.state.Columns {
-		if len(col) < connect4.Height {
+		if len(col) < state.state.Height {
 			toret = append(toret, &Action{
 				Col: i,
 			})
 		}
 	}
+	if state.state.PopOut {
+		for i, col := range state.state.Columns {
+			if len(col) > 0 && col[0] == state.state.CurrentTurn {
+				toret = append(toret, &Action{
+					Col: i,
+					Pop: true,
+				})
+			}
+		}
+	}
 	return toret
 }
 
 func (agent *Agent) BaseState() ai.State {
 	return &State{
-		state: &connect4.UpdateGameState{},
+		state: &ctypes.UpdateGameState{},
 	}
 }
 
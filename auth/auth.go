@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"websockets/storage"
+)
+
+// SessionCookie holds the signed token handed out on login. It is separate from gameroom.SessionCookie,
+// which only identifies a seat within one room.
+const SessionCookie = "Session"
+
+const (
+	SessionLifetime   = 7 * 24 * time.Hour
+	MinPasswordLength = 8
+)
+
+var validId = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+var ErrBadLogin = fmt.Errorf("Incorrect Id or Password")
+var ErrNotLoggedIn = fmt.Errorf("Not Logged In")
+
+// Auth registers and logs in accounts, and works out who a request is from. Session tokens are
+// HMAC signed rather than stored, so they stop working if the secret changes.
+type Auth struct {
+	store  *storage.Store
+	secret []byte
+}
+
+func NewAuth(store *storage.Store, secret []byte) *Auth {
+	return &Auth{
+		store:  store,
+		secret: secret,
+	}
+}
+
+// NewSecret returns a random secret, for servers that weren't given one.
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+func (auth *Auth) Register(id, password string, bot bool) error {
+	if !validId.MatchString(id) {
+		return fmt.Errorf("Ids must be 1 to 32 letters, digits, '_' or '-'")
+	}
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("Passwords must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return auth.store.CreateAccount(&storage.Account{
+		Id:           id,
+		PasswordHash: hash,
+		Bot:          bot,
+		CreatedAt:    time.Now(),
+	})
+}
+
+// Login checks id's password and returns a session token for it.
+func (auth *Auth) Login(id, password string) (string, error) {
+	account, err := auth.store.Account(id)
+	if err != nil {
+		return "", ErrBadLogin
+	}
+	if bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)) != nil {
+		return "", ErrBadLogin
+	}
+	return auth.Token(id, time.Now().Add(SessionLifetime)), nil
+}
+
+func (auth *Auth) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, auth.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Token returns a session token for id that is valid until expires.
+func (auth *Auth) Token(id string, expires time.Time) string {
+	payload := id + ":" + strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(auth.sign(payload))
+}
+
+// Verify returns the account id a session token was issued to.
+func (auth *Auth) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("Malformed Token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("Malformed Token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, auth.sign(string(payload))) {
+		return "", fmt.Errorf("Invalid Token")
+	}
+	sep := strings.LastIndex(string(payload), ":")
+	if sep < 0 {
+		return "", fmt.Errorf("Malformed Token")
+	}
+	expires, err := strconv.ParseInt(string(payload[sep+1:]), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Malformed Token")
+	}
+	if time.Now().Unix() >= expires {
+		return "", fmt.Errorf("Token Expired")
+	}
+	return string(payload[:sep]), nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey creates a key that authenticates as accountId. Only its hash is kept, so the key can't be shown again.
+func (auth *Auth) NewAPIKey(accountId string) (string, error) {
+	raw := make([]byte, 24)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(raw)
+	err = auth.store.AddAPIKey(accountId, hashKey(key))
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Authenticate returns the account r is from. It accepts "Authorization: Bearer" with either a session token
+// or an API key, then falls back to the session cookie browsers send.
+func (auth *Auth) Authenticate(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		credential := strings.TrimPrefix(header, "Bearer ")
+		if id, err := auth.Verify(credential); err == nil {
+			return id, nil
+		}
+		id, err := auth.store.APIKeyAccount(hashKey(credential))
+		if err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		return auth.Verify(cookie.Value)
+	}
+	return "", ErrNotLoggedIn
+}
+
+// SetSession sets the session cookie for token on the response.
+func SetSession(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(SessionLifetime),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
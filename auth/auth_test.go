@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"websockets/storage"
+)
+
+func newTestAuth(t *testing.T) (*Auth, func()) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.Open(filepath.Join(dir, "auth.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return NewAuth(store, []byte("test secret")), func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	auth, cleanup := newTestAuth(t)
+	defer cleanup()
+
+	if err := auth.Register("alice", "short", false); err == nil {
+		t.Error("registered with a short password")
+	}
+	if err := auth.Register("alice?", "long enough", false); err == nil {
+		t.Error("registered with an invalid id")
+	}
+	if err := auth.Register("alice", "long enough", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.Register("alice", "something else", false); err != storage.ErrAccountExists {
+		t.Errorf("registering twice: got %v", err)
+	}
+	if _, err := auth.Login("alice", "wrong password"); err != ErrBadLogin {
+		t.Errorf("wrong password: got %v", err)
+	}
+	if _, err := auth.Login("bob", "long enough"); err != ErrBadLogin {
+		t.Errorf("unknown account: got %v", err)
+	}
+	token, err := auth.Login("alice", "long enough")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/game/room", nil)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: token})
+	if id, err := auth.Authenticate(r); err != nil || id != "alice" {
+		t.Errorf("cookie: got %q, %v", id, err)
+	}
+	r = httptest.NewRequest("GET", "/game/room", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if id, err := auth.Authenticate(r); err != nil || id != "alice" {
+		t.Errorf("bearer token: got %q, %v", id, err)
+	}
+	r = httptest.NewRequest("GET", "/game/room?userId=alice", nil)
+	if _, err := auth.Authenticate(r); err != ErrNotLoggedIn {
+		t.Errorf("no credentials: got %v", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	auth, cleanup := newTestAuth(t)
+	defer cleanup()
+
+	token := auth.Token("alice", time.Now().Add(time.Hour))
+	if id, err := auth.Verify(token); err != nil || id != "alice" {
+		t.Errorf("got %q, %v", id, err)
+	}
+	if _, err := auth.Verify(auth.Token("alice", time.Now().Add(-time.Second))); err == nil {
+		t.Error("verified an expired token")
+	}
+	forged := auth.Token("mallory", time.Now().Add(time.Hour))
+	if _, err := auth.Verify(forged[:len(forged)-2] + "AA"); err == nil {
+		t.Error("verified a token with a bad signature")
+	}
+	other := NewAuth(nil, []byte("other secret"))
+	if _, err := other.Verify(token); err == nil {
+		t.Error("verified a token signed with another secret")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	auth, cleanup := newTestAuth(t)
+	defer cleanup()
+
+	if err := auth.Register("minmax", "bot password", true); err != nil {
+		t.Fatal(err)
+	}
+	key, err := auth.NewAPIKey("minmax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/game/room", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	if id, err := auth.Authenticate(r); err != nil || id != "minmax" {
+		t.Errorf("got %q, %v", id, err)
+	}
+	r.Header.Set("Authorization", "Bearer not"+key)
+	if _, err := auth.Authenticate(r); err == nil {
+		t.Error("authenticated with an unknown key")
+	}
+}
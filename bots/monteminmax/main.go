@@ -1,15 +1,46 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
 	"websockets/ai"
 	"websockets/ai/monteminmax/connect4ai"
+	"websockets/matchmaking"
 )
 
 func main() {
-	a := &connect4ai.Agent{
-		AgentId: "monteminmax",
+	matchmake := flag.Bool("matchmake", false, "Queue for a standard Connect4 game instead of joining a room")
+	opponent := flag.String("opponent", "", "With -matchmake, only play this opponent")
+	ratingRange := flag.Float64("range", 0, "With -matchmake, only play opponents rated within this many points")
+	budget := flag.Duration("budget", connect4ai.DefaultBudget, "Time to think about each move in untimed games; timed games go by the clock")
+	flag.Parse()
+	key := os.Getenv(ai.APIKeyEnv)
+	room := flag.Arg(0)
+	if *matchmake {
+		var err error
+		room, err = ai.Matchmake("ws://localhost:8080/matchmake", key, &matchmaking.Request{
+			Game:        "connect4",
+			Opponent:    *opponent,
+			RatingRange: *ratingRange,
+		})
+		if err != nil {
+			panic(err)
+		}
 	}
-	agent, err := ai.NewAgent(a, "ws://localhost:8080/game?userId=monteminmax")
+	if room == "" {
+		fmt.Println("usage: monteminmax [flags] <roomId>, or monteminmax -matchmake [flags]")
+		fmt.Println("The bot plays as the account whose API key is in $" + ai.APIKeyEnv + ".")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	id, err := ai.Account("http://localhost:8080/login", key)
+	if err != nil {
+		panic(err)
+	}
+	a := connect4ai.NewAgent(id)
+	a.Budget = *budget
+	agent, err := ai.NewAgent(a, "ws://localhost:8080/game/"+room, key)
 	if err != nil {
 		panic(err)
 	}
@@ -1,18 +1,55 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
+	"runtime"
 	"websockets/ai"
 	"websockets/ai/montecarlotree/connect4ai"
+	"websockets/matchmaking"
 )
 
 func main() {
-	id := "montetree"
-	if len(os.Args) > 1 {
-		id = os.Args[1]
+	matchmake := flag.Bool("matchmake", false, "Queue for a standard Connect4 game instead of joining a room")
+	opponent := flag.String("opponent", "", "With -matchmake, only play this opponent")
+	ratingRange := flag.Float64("range", 0, "With -matchmake, only play opponents rated within this many points")
+	budget := flag.Duration("budget", connect4ai.DefaultBudget, "Time to think about each move in untimed games; timed games go by the clock")
+	workers := flag.Int("workers", runtime.NumCPU(), "Goroutines to search with")
+	tree := flag.Bool("tree", false, "Have the workers share one tree, rather than each search their own and pool the results")
+	exploration := flag.Float64("exploration", connect4ai.DefaultExploration, "UCB1 exploration constant; higher tries more moves, lower backs the best ones")
+	flag.Parse()
+	key := os.Getenv(ai.APIKeyEnv)
+	room := flag.Arg(0)
+	if *matchmake {
+		var err error
+		room, err = ai.Matchmake("ws://localhost:8080/matchmake", key, &matchmaking.Request{
+			Game:        "connect4",
+			Opponent:    *opponent,
+			RatingRange: *ratingRange,
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	if room == "" {
+		fmt.Println("usage: montetree [flags] <roomId>, or montetree -matchmake [flags]")
+		fmt.Println("The bot plays as the account whose API key is in $" + ai.APIKeyEnv + ".")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	id, err := ai.Account("http://localhost:8080/login", key)
+	if err != nil {
+		panic(err)
 	}
 	a := connect4ai.NewAgent(id)
-	agent, err := ai.NewAgent(a, "ws://localhost:8080/game?userId="+id)
+	a.Exploration = *exploration
+	a.Budget = *budget
+	a.Workers = *workers
+	if *tree {
+		a.Parallelism = connect4ai.TreeParallel
+	}
+	agent, err := ai.NewAgent(a, "ws://localhost:8080/game/"+room, key)
 	if err != nil {
 		panic(err)
 	}
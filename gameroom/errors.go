@@ -0,0 +1,56 @@
+package gameroom
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"time"
+)
+
+// Close codes in the private 4000-4999 range that the room uses when it ends a socket.
+const (
+	// CloseSuperseded is sent to a socket that has been replaced by a newer connection for the same player.
+	CloseSuperseded = 4000
+	// CloseJoinRejected is sent when the game refuses the player, e.g. because it is full.
+	CloseJoinRejected = 4001
+	// CloseAlreadyConnected is sent when another socket holds the player's session and no token was presented.
+	CloseAlreadyConnected = 4002
+	// CloseNoRoom is sent when the requested room does not exist.
+	CloseNoRoom = 4003
+	// CloseNoReplay is sent when a finished game has no updates to replay.
+	CloseNoReplay = 4004
+	// CloseMatchFailed is sent when the matchmaker can't queue a player or seat a match.
+	CloseMatchFailed = 4005
+)
+
+const writeWait = time.Second
+
+// ErrorMessage reports a failure to a client. Code is the close code that follows it, or 0 if the socket stays open.
+type ErrorMessage struct {
+	Error string
+	Code  int
+}
+
+// CloseWithError reports err to the client as an ErrorMessage, then closes the socket with code.
+func CloseWithError(conn *websocket.Conn, code int, err error) {
+	fmt.Println("CLOSING CONNECTION:", err.Error())
+	msg, _ := json.Marshal(&ErrorMessage{
+		Error: err.Error(),
+		Code:  code,
+	})
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	conn.WriteMessage(websocket.TextMessage, msg)
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, err.Error()), time.Now().Add(writeWait))
+	conn.Close()
+}
+
+// Reject upgrades a request that can't be served only to report err to the client and close with code,
+// since browsers can't read the body of a failed WebSocket handshake.
+func Reject(w http.ResponseWriter, r *http.Request, code int, err error) {
+	c, upgradeErr := upgrader.Upgrade(w, r, nil)
+	if upgradeErr != nil {
+		return
+	}
+	CloseWithError(c, code, err)
+}
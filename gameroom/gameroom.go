@@ -1,104 +1,302 @@
 package gameroom
 
 import (
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/satori/go.uuid"
 	"net/http"
+	"sync"
+	"time"
 	"websockets/games/types"
 )
 
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
 type GameRoom struct {
-	Id                string
-	game              types.Game
-	playerConnections map[string]chan bool
+	Id          string
+	game        types.Game
+	mutex       sync.Mutex
+	connections int
+	// emptySince is when the last socket left, or when the room opened if nobody has connected yet.
+	emptySince time.Time
+	// closing is set, under mutex, once the room has started to close, and turns away new connections.
+	closing  bool
+	sessions map[string]*playerSession
+	// reserved, when set, are the only players who may take a seat. Anyone can still spectate.
+	reserved map[string]bool
+	closed   chan bool
+	// moveLock is held for reading while a move is sent, so Close can't close the game's move channel under a sender.
+	moveLock sync.RWMutex
+	isClosed bool
 }
 
 func NewGameRoom(game types.Game) (*GameRoom, error) {
 	id := uuid.NewV4()
 	return &GameRoom{
-		Id:                id.String(),
-		game:              game,
-		playerConnections: map[string]chan bool{},
+		Id:         id.String(),
+		game:       game,
+		emptySince: time.Now(),
+		sessions:   map[string]*playerSession{},
+		closed:     make(chan bool),
 	}, nil
 }
 
+// Connections returns the number of sockets currently connecting to or attached to the room.
+func (gr *GameRoom) Connections() int {
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+	return gr.connections
+}
+
+// CloseIfIdle closes the room if nobody is connected and either its game has ended or it has been empty for
+// timeout. Nobody can connect between the check and the close. It reports whether the room was closed.
+func (gr *GameRoom) CloseIfIdle(timeout time.Duration) bool {
+	finished := gr.Finished()
+	gr.mutex.Lock()
+	idle := !gr.closing && gr.connections == 0 && (finished || time.Since(gr.emptySince) >= timeout)
+	if idle {
+		gr.closing = true
+	}
+	gr.mutex.Unlock()
+	if idle {
+		gr.Close()
+	}
+	return idle
+}
+
+// enter counts a new connection, unless the room is closing.
+func (gr *GameRoom) enter() bool {
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+	if gr.closing {
+		return false
+	}
+	gr.connections += 1
+	return true
+}
+
+func (gr *GameRoom) leave() {
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+	gr.connections -= 1
+	if gr.connections == 0 {
+		gr.emptySince = time.Now()
+	}
+}
+
+// Reserve keeps the room's seats for playerIds, such as the two sides of a matchmade game.
+func (gr *GameRoom) Reserve(playerIds ...string) {
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+	gr.reserved = map[string]bool{}
+	for _, playerId := range playerIds {
+		gr.reserved[playerId] = true
+	}
+}
+
+func (gr *GameRoom) mayJoin(playerId string) bool {
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+	return gr.reserved == nil || gr.reserved[playerId]
+}
+
+// Finished reports whether the hosted game has ended. Games that can't tell are never finished.
+func (gr *GameRoom) Finished() bool {
+	if finisher, ok := gr.game.(types.Finisher); ok {
+		return finisher.Finished()
+	}
+	return false
+}
+
+func (gr *GameRoom) Close() {
+	gr.mutex.Lock()
+	gr.closing = true
+	gr.mutex.Unlock()
+	gr.moveLock.Lock()
+	defer gr.moveLock.Unlock()
+	if gr.isClosed {
+		return
+	}
+	gr.isClosed = true
+	close(gr.closed)
+	gr.game.Close()
+}
+
+// sendMove hands move to the game unless the room has been closed.
+func (gr *GameRoom) sendMove(moveChan chan<- *types.Move, move *types.Move) bool {
+	gr.moveLock.RLock()
+	defer gr.moveLock.RUnlock()
+	if gr.isClosed {
+		return false
+	}
+	moveChan <- move
+	return true
+}
+
+// requestToken reads the session token from its cookie only, keeping it out of URLs and access logs. Clients
+// other than browsers, such as the bots, send the cookie header themselves.
+func requestToken(r *http.Request) string {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// claimSession finds or creates the session for playerId. A session that currently has a socket can only be
+// taken over by a request presenting its token; an idle session is resumed by any request for that player.
+func (gr *GameRoom) claimSession(playerId, token string, spectator bool) (*playerSession, bool, error) {
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+	if session, ok := gr.sessions[playerId]; ok {
+		if token != session.token && session.attached() {
+			return nil, false, fmt.Errorf("Player %s is already connected", playerId)
+		}
+		return session, false, nil
+	}
+	session := &playerSession{
+		token:     uuid.NewV4().String(),
+		spectator: spectator,
+	}
+	gr.sessions[playerId] = session
+	return session, true, nil
+}
+
 func (gr *GameRoom) ConnectToGame(playerId string, w http.ResponseWriter, r *http.Request) {
-	var upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+	spectator := r.URL.Query().Get("spectate") == "true"
+	if !gr.enter() {
+		Reject(w, r, CloseNoRoom, fmt.Errorf("Room %s is closed", gr.Id))
+		return
 	}
-	c, err := upgrader.Upgrade(w, r, nil)
+	defer gr.leave()
+	session, created, claimErr := gr.claimSession(playerId, requestToken(r), spectator)
+
+	header := http.Header{}
+	if claimErr == nil {
+		cookie := &http.Cookie{
+			Name:     SessionCookie,
+			Value:    session.token,
+			Path:     "/game/" + gr.Id,
+			HttpOnly: true,
+		}
+		header.Set("Set-Cookie", cookie.String())
+	}
+	c, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
-		// Actually, return some sort of error status.
-		panic(err)
+		// The upgrader has already replied with an HTTP error.
+		fmt.Println("UPGRADE FAILED:", err.Error())
+		if created {
+			gr.dropSession(playerId)
+		}
+		return
+	}
+	if claimErr != nil {
+		CloseWithError(c, CloseAlreadyConnected, claimErr)
+		return
 	}
 
-	err = gr.game.Join(playerId)
-	if err != nil {
-		panic(err)
+	if created {
+		err = gr.joinGame(playerId, session)
+		if err != nil {
+			gr.dropSession(playerId)
+			CloseWithError(c, CloseJoinRejected, err)
+			return
+		}
 	}
 	// Commenting out, because a player disconnecting does not indicate a player left IF we don't want a game tied to a single browser visit.
 	// defer gr.game.Leave(playerId)
 
-	gr.runPlayerSession(playerId, c)
+	gr.runPlayerSession(playerId, session, c)
+}
+
+func (gr *GameRoom) joinGame(playerId string, session *playerSession) error {
+	if session.spectator {
+		return gr.spectateGame(playerId, session)
+	}
+	if !gr.mayJoin(playerId) {
+		return fmt.Errorf("Seats are reserved for other players")
+	}
+	err := gr.game.Join(playerId)
+	if err != nil {
+		return err
+	}
+	updates, err := gr.game.UpdatesChannel(playerId)
+	if err != nil {
+		gr.game.Leave(playerId)
+		return err
+	}
+	go session.forwardUpdates(updates, gr.closed)
+	return nil
 }
 
-func (gr *GameRoom) runPlayerSession(playerId string, conn *websocket.Conn) {
-	_, ok := gr.playerConnections[playerId]
-	if ok {
-		// Already opened in another browser, probably. Figure out how to handle this?
-		// Or refreshed, apparently.
+func (gr *GameRoom) spectateGame(spectatorId string, session *playerSession) error {
+	spectatable, ok := gr.game.(types.Spectatable)
+	if !ok {
+		return fmt.Errorf("Game does not allow spectators")
+	}
+	err := spectatable.Spectate(spectatorId)
+	if err != nil {
+		return err
+	}
+	updates, err := gr.game.UpdatesChannel(spectatorId)
+	if err != nil {
+		spectatable.StopSpectating(spectatorId)
+		return err
 	}
-	ch := make(chan bool, 1)
-	gr.playerConnections[playerId] = ch
-	go gr.forwardGameUpdates(playerId, conn)
-	gr.forwardGameMoves(playerId, conn)
-	ch <- true
+	go session.forwardUpdates(updates, gr.closed)
+	return nil
+}
+
+func (gr *GameRoom) dropSession(playerId string) {
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+	delete(gr.sessions, playerId)
 }
 
-func (gr *GameRoom) forwardGameMoves(playerId string, conn *websocket.Conn) {
+func (gr *GameRoom) runPlayerSession(playerId string, session *playerSession, conn *websocket.Conn) {
+	session.attach(conn)
+	gr.forwardGameMoves(playerId, session, conn)
+	current := session.detach(conn)
+	conn.Close()
+	if current && session.spectator {
+		// Spectators have no seat to come back to, so they stop watching as soon as they disconnect.
+		gr.dropSession(playerId)
+		gr.game.(types.Spectatable).StopSpectating(playerId)
+	}
+}
+
+func (gr *GameRoom) forwardGameMoves(playerId string, session *playerSession, conn *websocket.Conn) {
 	moveChan, err := gr.game.MovesChannel(playerId)
 	if err != nil {
-		panic(err)
+		session.close(conn, websocket.CloseInternalServerErr, err)
+		return
 	}
 	for {
 		mtype, msg, err := conn.ReadMessage()
 		if err != nil {
-			switch err.(type) {
-			case *websocket.CloseError:
-				return
-			default:
-				panic(err)
+			// Closed, superseded or dropped; the session lives on for a reconnect.
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, CloseSuperseded) {
+				fmt.Println("READ FAILED FOR PLAYER "+playerId+":", err.Error())
 			}
+			return
 		}
 		switch mtype {
 		case websocket.TextMessage:
+			if session.spectator {
+				session.sendError(fmt.Errorf("Spectators can't make moves"))
+				continue
+			}
 			move := &types.Move{
 				PlayerId: playerId,
 				Data:     msg,
 			}
-			moveChan <- move
-		default:
-			continue
-		}
-	}
-}
-
-func (gr *GameRoom) forwardGameUpdates(playerId string, conn *websocket.Conn) {
-	updates, err := gr.game.UpdatesChannel(playerId)
-	if err != nil {
-		panic(err)
-	}
-	for {
-		select {
-		case <-gr.playerConnections[playerId]:
-			// We've received word that the connection is closed
-			return
-		case update := <-updates:
-			err := conn.WriteMessage(websocket.TextMessage, update)
-			if err != nil {
-				// Can't write, connection is probably closed
+			if !gr.sendMove(moveChan, move) {
+				session.close(conn, websocket.CloseGoingAway, fmt.Errorf("Room closed"))
 				return
 			}
+		default:
+			continue
 		}
 	}
 }
@@ -0,0 +1,220 @@
+package gameroom
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"websockets/games/connect4"
+	ctypes "websockets/games/connect4/types"
+)
+
+func newTestRoom(t *testing.T) (*GameRoom, string, func()) {
+	room, err := NewGameRoom(connect4.NewConnect4(ctypes.DefaultOptions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		room.ConnectToGame(r.URL.Query().Get("userId"), w, r)
+	}))
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/game/" + room.Id + "?userId="
+	return room, url, func() {
+		server.Close()
+		room.Close()
+	}
+}
+
+func dial(t *testing.T, url string) (*websocket.Conn, string) {
+	return redial(t, url, "")
+}
+
+// redial connects presenting the session token from an earlier connection, as a browser would its cookie.
+func redial(t *testing.T, url, token string) (*websocket.Conn, string) {
+	header := http.Header{}
+	if token != "" {
+		header.Set("Cookie", (&http.Cookie{Name: SessionCookie, Value: token}).String())
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Error(err)
+		return nil, ""
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == SessionCookie {
+			return conn, cookie.Value
+		}
+	}
+	return conn, ""
+}
+
+func readAll(conn *websocket.Conn, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func waitForNoConnections(t *testing.T, room *GameRoom) {
+	deadline := time.Now().Add(5 * time.Second)
+	for room.Connections() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("room still has %d connections", room.Connections())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConcurrentConnectionsMovesAndDisconnects(t *testing.T) {
+	room, url, cleanup := newTestRoom(t)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	var readers sync.WaitGroup
+	for _, playerId := range []string{"red", "black"} {
+		wg.Add(1)
+		go func(playerId string) {
+			defer wg.Done()
+			conn, token := dial(t, url+playerId)
+			if conn == nil {
+				return
+			}
+			readers.Add(1)
+			go readAll(conn, &readers)
+			for i := 0; i < 50; i += 1 {
+				conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"Col": %d}`, i%7)))
+				if i%10 == 9 {
+					// Reconnect mid-game, superseding the previous socket.
+					next, _ := redial(t, url+playerId, token)
+					if next == nil {
+						return
+					}
+					readers.Add(1)
+					go readAll(next, &readers)
+					conn = next
+				}
+			}
+			conn.Close()
+		}(playerId)
+	}
+	for i := 0; i < 5; i += 1 {
+		wg.Add(1)
+		go func(spectatorId string) {
+			defer wg.Done()
+			conn, _ := dial(t, url+spectatorId+"&spectate=true")
+			if conn == nil {
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"Col": 0}`))
+			conn.ReadMessage()
+			conn.Close()
+		}(fmt.Sprintf("spectator%d", i))
+	}
+	wg.Wait()
+	readers.Wait()
+	waitForNoConnections(t, room)
+}
+
+func TestThirdPlayerRejected(t *testing.T) {
+	room, url, cleanup := newTestRoom(t)
+	defer cleanup()
+	for _, playerId := range []string{"red", "black"} {
+		conn, _ := dial(t, url+playerId)
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+	}
+	conn, _ := dial(t, url+"late")
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+	msg := &ErrorMessage{}
+	if err := conn.ReadJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != CloseJoinRejected {
+		t.Errorf("expected close code %d, got %+v", CloseJoinRejected, msg)
+	}
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, CloseJoinRejected) {
+		t.Errorf("expected close error %d, got %v", CloseJoinRejected, err)
+	}
+	if room.Connections() > 2 {
+		t.Errorf("expected at most 2 connections, got %d", room.Connections())
+	}
+}
+
+func TestCloseIfIdle(t *testing.T) {
+	room, url, cleanup := newTestRoom(t)
+	defer cleanup()
+	conn, _ := dial(t, url+"red")
+	if conn == nil {
+		return
+	}
+	if room.CloseIfIdle(0) {
+		t.Fatal("a room with a player connected isn't idle")
+	}
+	conn.Close()
+	waitForNoConnections(t, room)
+	if !room.CloseIfIdle(0) {
+		t.Fatal("expected the empty room to close")
+	}
+
+	late, _ := dial(t, url+"black")
+	if late == nil {
+		return
+	}
+	defer late.Close()
+	msg := &ErrorMessage{}
+	if err := late.ReadJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != CloseNoRoom {
+		t.Errorf("expected a closed room to turn players away with %d, got %+v", CloseNoRoom, msg)
+	}
+}
+
+func TestReservedSeats(t *testing.T) {
+	room, url, cleanup := newTestRoom(t)
+	defer cleanup()
+	room.Reserve("red", "black")
+
+	conn, _ := dial(t, url+"intruder")
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+	msg := &ErrorMessage{}
+	if err := conn.ReadJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != CloseJoinRejected {
+		t.Errorf("expected close code %d, got %+v", CloseJoinRejected, msg)
+	}
+
+	watcher, _ := dial(t, url+"intruder&spectate=true")
+	if watcher == nil {
+		return
+	}
+	defer watcher.Close()
+	msg = &ErrorMessage{}
+	if err := watcher.ReadJSON(msg); err != nil || msg.Error != "" {
+		t.Errorf("expected spectating to be allowed, got %+v (%v)", msg, err)
+	}
+	red, _ := dial(t, url+"red")
+	if red == nil {
+		return
+	}
+	defer red.Close()
+	msg = &ErrorMessage{}
+	if err := red.ReadJSON(msg); err != nil || msg.Error != "" {
+		t.Errorf("expected red to be seated, got %+v (%v)", msg, err)
+	}
+}
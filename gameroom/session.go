@@ -0,0 +1,103 @@
+package gameroom
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+	"time"
+)
+
+// SessionCookie carries the session token issued when a player first joins a room.
+const SessionCookie = "GameSession"
+
+// playerSession outlives any single socket. It owns the player's update channel for the lifetime of the room
+// and writes each update to whichever connection is current, remembering the latest for reconnects.
+type playerSession struct {
+	token      string
+	spectator  bool
+	mutex      sync.Mutex
+	conn       *websocket.Conn
+	lastUpdate []byte
+}
+
+// attach makes conn the session's socket, closing any previous socket and replaying the latest update.
+func (s *playerSession) attach(conn *websocket.Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil {
+		CloseWithError(s.conn, CloseSuperseded, fmt.Errorf("Superseded by a new connection"))
+	}
+	s.conn = conn
+	if s.lastUpdate != nil {
+		s.write(s.lastUpdate)
+	}
+}
+
+// detach forgets conn, unless it has already been superseded. It reports whether conn was still current.
+func (s *playerSession) detach(conn *websocket.Conn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == conn {
+		s.conn = nil
+		return true
+	}
+	return false
+}
+
+// sendError reports a non-fatal error to the current socket, leaving it open.
+func (s *playerSession) sendError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return
+	}
+	msg, _ := json.Marshal(&ErrorMessage{
+		Error: err.Error(),
+	})
+	s.write(msg)
+}
+
+// write sends msg to the current socket, which the caller holds the lock for. A client that stops reading
+// has its socket closed once the write times out, rather than holding up the room; its reader then detaches it.
+func (s *playerSession) write(msg []byte) {
+	s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := s.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		s.conn.Close()
+	}
+}
+
+// close reports err and closes conn with code, under the lock so it can't interleave with another write.
+// A superseded conn has already been closed.
+func (s *playerSession) close(conn *websocket.Conn, code int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == conn {
+		CloseWithError(conn, code, err)
+	}
+}
+
+func (s *playerSession) attached() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conn != nil
+}
+
+func (s *playerSession) forwardUpdates(updates <-chan []byte, closed <-chan bool) {
+	for {
+		select {
+		case <-closed:
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			s.mutex.Lock()
+			s.lastUpdate = update
+			if s.conn != nil {
+				s.write(update)
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package connect4
+
+import (
+	"time"
+	ctypes "websockets/games/connect4/types"
+)
+
+// clock keeps both players' time under a TimeControl. Only the player to move has their time running,
+// and only once the clock has been started.
+type clock struct {
+	control   ctypes.TimeControl
+	remaining [2]time.Duration
+	running   bool
+	turn      ctypes.Color
+	turnStart time.Time
+}
+
+// newClock returns nil for untimed games.
+func newClock(control ctypes.TimeControl) *clock {
+	if control.Kind == ctypes.ClockNone {
+		return nil
+	}
+	full := time.Duration(control.Initial) * time.Millisecond
+	if control.Kind == ctypes.ClockPerMove {
+		full = time.Duration(control.PerMove) * time.Millisecond
+	}
+	return &clock{
+		control:   control,
+		remaining: [2]time.Duration{full, full},
+	}
+}
+
+// start runs turn's time from now.
+func (c *clock) start(turn ctypes.Color, now time.Time) {
+	c.running = true
+	c.turn = turn
+	c.turnStart = now
+}
+
+// stop charges whoever is to move for their time so far and stops both clocks.
+func (c *clock) stop(now time.Time) {
+	if c.running {
+		c.remaining[c.turn] = c.left(c.turn, now)
+		c.running = false
+	}
+}
+
+func (c *clock) left(color ctypes.Color, now time.Time) time.Duration {
+	if c.running && color == c.turn {
+		return c.remaining[color] - now.Sub(c.turnStart)
+	}
+	return c.remaining[color]
+}
+
+// flagged reports whether the player to move has run out of time.
+func (c *clock) flagged(now time.Time) bool {
+	return c.running && c.left(c.turn, now) <= 0
+}
+
+// moved charges the player to move for their turn, credits them anything the time control gives back,
+// and starts next's time.
+func (c *clock) moved(next ctypes.Color, now time.Time) {
+	if !c.running {
+		return
+	}
+	mover := c.turn
+	c.remaining[mover] = c.left(mover, now)
+	switch c.control.Kind {
+	case ctypes.ClockFischer:
+		c.remaining[mover] += time.Duration(c.control.Increment) * time.Millisecond
+	case ctypes.ClockPerMove:
+		c.remaining[mover] = time.Duration(c.control.PerMove) * time.Millisecond
+	}
+	c.start(next, now)
+}
+
+// snapshot is each color's time left in milliseconds, for updates.
+func (c *clock) snapshot(now time.Time) []int64 {
+	toret := []int64{}
+	for _, color := range []ctypes.Color{ctypes.Red, ctypes.Black} {
+		left := c.left(color, now)
+		if left < 0 {
+			left = 0
+		}
+		toret = append(toret, ctypes.Millis(left))
+	}
+	return toret
+}
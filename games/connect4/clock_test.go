@@ -0,0 +1,86 @@
+package connect4
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+	ctypes "websockets/games/connect4/types"
+)
+
+func TestClockKinds(t *testing.T) {
+	start := time.Now()
+	cases := []struct {
+		control ctypes.TimeControl
+		// Red's time left after taking 3s over a move, and Black's after taking 4s.
+		red, black time.Duration
+	}{
+		{ctypes.TimeControl{Kind: ctypes.ClockSuddenDeath, Initial: 60000}, 57 * time.Second, 56 * time.Second},
+		{ctypes.TimeControl{Kind: ctypes.ClockFischer, Initial: 60000, Increment: 2000}, 59 * time.Second, 58 * time.Second},
+		{ctypes.TimeControl{Kind: ctypes.ClockPerMove, PerMove: 10000}, 10 * time.Second, 10 * time.Second},
+	}
+	for _, tc := range cases {
+		c := newClock(tc.control)
+		c.start(ctypes.Red, start)
+		c.moved(ctypes.Black, start.Add(3*time.Second))
+		c.moved(ctypes.Red, start.Add(7*time.Second))
+		if c.remaining[ctypes.Red] != tc.red || c.remaining[ctypes.Black] != tc.black {
+			t.Errorf("%s: got %v, want red %v black %v", tc.control, c.remaining, tc.red, tc.black)
+		}
+	}
+	if newClock(ctypes.TimeControl{}) != nil {
+		t.Error("untimed games shouldn't have a clock")
+	}
+}
+
+func TestClockOnlyRunsForPlayerToMove(t *testing.T) {
+	start := time.Now()
+	c := newClock(ctypes.TimeControl{Kind: ctypes.ClockSuddenDeath, Initial: 5000})
+	if c.flagged(start.Add(time.Hour)) {
+		t.Error("a clock that hasn't started can't flag")
+	}
+	c.start(ctypes.Red, start)
+	if left := c.left(ctypes.Black, start.Add(time.Second)); left != 5*time.Second {
+		t.Errorf("black's clock ran on red's turn: %v", left)
+	}
+	if c.flagged(start.Add(4 * time.Second)) {
+		t.Error("flagged early")
+	}
+	if !c.flagged(start.Add(5 * time.Second)) {
+		t.Error("didn't flag")
+	}
+	if snapshot := c.snapshot(start.Add(6 * time.Second)); snapshot[ctypes.Red] != 0 || snapshot[ctypes.Black] != 5000 {
+		t.Errorf("unexpected snapshot %v", snapshot)
+	}
+}
+
+func TestTimeoutLoses(t *testing.T) {
+	options := ctypes.DefaultOptions()
+	options.TimeControl = ctypes.TimeControl{Kind: ctypes.ClockPerMove, PerMove: 50}
+	game := NewConnect4(options)
+	defer game.Close()
+	game.Join("red")
+	game.Join("black")
+	updates, _ := game.UpdatesChannel("black")
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-updates:
+			update := &ctypes.UpdateGameState{}
+			if err := json.Unmarshal(msg, update); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := update.TimeLeft(ctypes.Red); !ok {
+				t.Fatal("timed games should report remaining time")
+			}
+			if !update.GameOver {
+				continue
+			}
+			if update.Result.WinnerId != "black" || update.Result.Reason != ctypes.ReasonTimeout {
+				t.Errorf("expected black to win on time, got %+v", update.Result)
+			}
+			return
+		case <-timeout:
+			t.Fatal("red never ran out of time")
+		}
+	}
+}
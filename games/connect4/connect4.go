@@ -1,35 +1,170 @@
 package connect4
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+	"websockets/games"
 	ctypes "websockets/games/connect4/types"
 	"websockets/games/types"
 )
 
+// Connect4 is safe for concurrent use. The game loop and the room's connection goroutines share
+// state, players and spectators under mutex; methods with lowercase names expect it to be held.
+// seats holds the id of the player at each color, or "" for an empty seat.
 type Connect4 struct {
+	mutex       sync.Mutex
 	state       ctypes.GameState
 	players     map[string]*ctypes.PlayerInfo
+	seats       [2]string
+	spectators  map[string]chan []byte
 	moveChannel chan *types.Move
+	startedAt   time.Time
+	finishHooks []FinishHook
+	// updateLog holds every distinct update sent during the current game, for replays.
+	updateLog [][]byte
+	// finished holds a game over until its final update has been sent and runFinishHooks can hand it to the finish hooks.
+	finished *ctypes.GameRecord
+	// firstPly is how much of the history had been played when the current game started, so imported
+	// starting moves don't stop a game being aborted.
+	firstPly int
+	// clock is nil in untimed games. clockReset wakes the game loop to rearm its timeout whenever the
+	// clock starts or changes turn.
+	clock      *clock
+	clockReset chan bool
+	// series is nil unless the room plays a best-of-N series.
+	series *ctypes.Series
 }
 
-func NewConnect4() *Connect4 {
+// FinishHook is called from the game loop with every game that ends, rematches included, once the
+// final update has been sent.
+type FinishHook func(record *ctypes.GameRecord)
+
+func init() {
+	games.Register("connect4", func(optionsJson []byte) (types.Game, error) {
+		options, err := ctypes.ParseOptions(optionsJson)
+		if err != nil {
+			return nil, err
+		}
+		start := &startPosition{}
+		if len(optionsJson) > 0 {
+			err = json.Unmarshal(optionsJson, start)
+			if err != nil {
+				return nil, err
+			}
+		}
+		game := NewConnect4(options)
+		err = game.importMoves(start.Moves)
+		if err != nil {
+			game.Close()
+			return nil, err
+		}
+		return game, nil
+	})
+}
+
+// startPosition is read from the same room options as ctypes.Options. Moves, in column digit notation,
+// are played out before anyone joins.
+type startPosition struct {
+	Moves string
+}
+
+func NewConnect4(options ctypes.Options) *Connect4 {
 	toret := &Connect4{
-		state:       ctypes.NewGameState(),
+		state:       ctypes.NewGameState(options),
 		players:     map[string]*ctypes.PlayerInfo{},
+		spectators:  map[string]chan []byte{},
 		moveChannel: make(chan *types.Move, 16),
+		startedAt:   time.Now(),
+		clock:       newClock(options.TimeControl),
+		clockReset:  make(chan bool, 1),
+	}
+	if options.BestOf > 1 {
+		toret.series = ctypes.NewSeries(options.BestOf)
 	}
 	go toret.gameLoop()
 	return toret
 }
 
+func (connect *Connect4) importMoves(notation string) error {
+	moves, err := ctypes.ParseNotation(notation)
+	if err != nil {
+		return err
+	}
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	for i, m := range moves {
+		if connect.state.GameOver {
+			return fmt.Errorf("Move %d is after the end of the game", i+1)
+		}
+		if m.Pop {
+			err = connect.popPiece(connect.state.CurrentTurn, m.Col)
+		} else {
+			err = connect.makeMove(connect.state.CurrentTurn, m.Col)
+		}
+		if err != nil {
+			return fmt.Errorf("Move %d: %s", i+1, err.Error())
+		}
+	}
+	// A game imported already finished isn't a new result.
+	connect.finished = nil
+	if connect.series != nil {
+		connect.series = ctypes.NewSeries(connect.state.BestOf)
+	}
+	connect.firstPly = len(connect.state.History)
+	return nil
+}
+
+// played does the bookkeeping for a move piece has just made: it goes in the history, the clock passes to the
+// opponent, and a draw offer the opponent had standing is declined.
+func (connect *Connect4) played(piece ctypes.Color, col int, pop bool) {
+	connect.record(piece, col, pop)
+	connect.clockMoved()
+	if offer := connect.state.DrawOffer; offer != "" && offer != connect.playerByPieceColor(piece) {
+		connect.state.DrawOffer = ""
+	}
+}
+
+func (connect *Connect4) record(piece ctypes.Color, col int, pop bool) {
+	connect.state.History = append(connect.state.History, ctypes.MoveRecord{
+		PlayerId: connect.playerByPieceColor(piece),
+		Color:    piece,
+		Col:      col,
+		Pop:      pop,
+		Time:     time.Now(),
+	})
+}
+
 func (connect *Connect4) playerByPieceColor(piece ctypes.Color) string {
-	for id, playerInfo := range connect.players {
-		if playerInfo.PlayerColor == piece {
-			return id
+	return connect.seats[piece]
+}
+
+// freeSeat picks the color the next player to join will play, or NoColor if both are taken. With
+// RandomColors, whoever sits down at an empty table is given either.
+func (connect *Connect4) freeSeat() ctypes.Color {
+	if connect.state.RandomColors && connect.seats[ctypes.Red] == "" && connect.seats[ctypes.Black] == "" {
+		return ctypes.Color(rand.Intn(2))
+	}
+	for color, id := range connect.seats {
+		if id == "" {
+			return ctypes.Color(color)
+		}
+	}
+	return ctypes.NoColor
+}
+
+// swapColors exchanges the players' seats, so whoever moved second last game moves first in the next.
+func (connect *Connect4) swapColors() {
+	connect.seats[ctypes.Red], connect.seats[ctypes.Black] = connect.seats[ctypes.Black], connect.seats[ctypes.Red]
+	for color, id := range connect.seats {
+		if info, ok := connect.players[id]; ok {
+			info.PlayerColor = ctypes.Color(color)
 		}
 	}
-	return ""
 }
 
 func (connect *Connect4) requestRematch(player *ctypes.PlayerInfo) {
@@ -39,55 +174,248 @@ func (connect *Connect4) requestRematch(player *ctypes.PlayerInfo) {
 		rematch = rematch && player.RematchAttempt
 	}
 	if rematch {
-		connect.state = ctypes.NewGameState()
+		connect.state = ctypes.NewGameState(connect.state.Options)
+		connect.firstPly = 0
+		connect.startedAt = time.Now()
+		connect.updateLog = nil
 		for _, player := range connect.players {
 			player.RematchAttempt = false
 		}
+		connect.swapColors()
+		if connect.series != nil {
+			if connect.series.Over {
+				connect.series = ctypes.NewSeries(connect.state.BestOf)
+			} else {
+				connect.series.Game = connect.series.Played() + 1
+			}
+		}
+		connect.clock = newClock(connect.state.TimeControl)
+		connect.startClock()
 	}
 }
 
 func (connect *Connect4) gameLoop() {
-	for move := range connect.moveChannel {
-		info, ok := connect.players[move.PlayerId]
-		if !ok {
-			continue
+	for {
+		select {
+		case move, ok := <-connect.moveChannel:
+			if !ok {
+				return
+			}
+			connect.handleMove(move)
+		case <-connect.clockReset:
+		case <-connect.flagTimer():
+			connect.mutex.Lock()
+			if connect.flagCheck() {
+				connect.sendUpdates()
+			}
+			connect.mutex.Unlock()
 		}
+		connect.runFinishHooks()
+	}
+}
 
-		m := &ctypes.MoveData{
-			Col: -1,
-		}
-		err := json.Unmarshal(move.Data, m)
-		if err != nil || (m.Col < 0 && !m.Rematch) {
-			continue
-		}
+// startClock starts the player to move's time once both players are seated.
+func (connect *Connect4) startClock() {
+	if connect.clock == nil || connect.clock.running || connect.state.GameOver || len(connect.players) < 2 {
+		return
+	}
+	connect.clock.start(connect.state.CurrentTurn, time.Now())
+	connect.resetFlagTimer()
+}
 
-		if m.Rematch {
-			connect.requestRematch(info)
-		} else {
-			err = connect.makeMove(info.PlayerColor, m.Col)
-			if err != nil {
-				continue
-			}
-		}
+func (connect *Connect4) resetFlagTimer() {
+	select {
+	case connect.clockReset <- true:
+	default:
+	}
+}
+
+// flagTimer fires when the player to move runs out of time, and never if no clock is running.
+func (connect *Connect4) flagTimer() <-chan time.Time {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	if connect.clock == nil || !connect.clock.running {
+		return nil
+	}
+	return time.After(connect.clock.left(connect.clock.turn, time.Now()))
+}
+
+// flagCheck ends the game if the player to move is out of time, reporting whether it did.
+func (connect *Connect4) flagCheck() bool {
+	if connect.clock == nil || connect.state.GameOver || !connect.clock.flagged(time.Now()) {
+		return false
+	}
+	connect.win(ctypes.Black-connect.clock.turn, ctypes.ReasonTimeout)
+	return true
+}
+
+// clockMoved hands the clock over to the player now to move.
+func (connect *Connect4) clockMoved() {
+	if connect.clock == nil {
+		return
+	}
+	connect.clock.moved(connect.state.CurrentTurn, time.Now())
+	connect.resetFlagTimer()
+}
+
+func (connect *Connect4) handleMove(move *types.Move) {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	info, ok := connect.players[move.PlayerId]
+	if !ok {
+		return
+	}
+
+	m := &ctypes.MoveData{
+		Col: -1,
+	}
+	err := json.Unmarshal(move.Data, m)
+	if err != nil {
+		return
+	}
+	// A move that arrives after its player's time ran out loses, even if the timer hasn't fired yet.
+	if connect.flagCheck() {
 		connect.sendUpdates()
+		return
+	}
+
+	switch {
+	case m.Rematch:
+		connect.requestRematch(info)
+	case m.Resign:
+		err = connect.resign(info.PlayerColor)
+	case m.OfferDraw:
+		err = connect.offerDraw(move.PlayerId)
+	case m.AcceptDraw, m.DeclineDraw:
+		err = connect.answerDraw(move.PlayerId, m.AcceptDraw)
+	case m.Abort:
+		err = connect.abort()
+	case m.Pop:
+		err = connect.popPiece(info.PlayerColor, m.Col)
+	default:
+		err = connect.makeMove(info.PlayerColor, m.Col)
+	}
+	if err != nil {
+		return
+	}
+	connect.sendUpdates()
+}
+
+func (connect *Connect4) resign(piece ctypes.Color) error {
+	if connect.state.GameOver {
+		return fmt.Errorf("Game is over")
+	}
+	if len(connect.players) < 2 {
+		return fmt.Errorf("No opponent to resign to")
+	}
+	connect.win(ctypes.Black-piece, ctypes.ReasonResignation)
+	return nil
+}
+
+func (connect *Connect4) offerDraw(playerId string) error {
+	if connect.state.GameOver {
+		return fmt.Errorf("Game is over")
+	}
+	if connect.state.DrawOffer != "" && connect.state.DrawOffer != playerId {
+		return connect.answerDraw(playerId, true)
+	}
+	connect.state.DrawOffer = playerId
+	return nil
+}
+
+// answerDraw accepts or declines the opponent's standing draw offer.
+func (connect *Connect4) answerDraw(playerId string, accept bool) error {
+	if connect.state.GameOver || connect.state.DrawOffer == "" || connect.state.DrawOffer == playerId {
+		return fmt.Errorf("No draw offer to answer")
+	}
+	connect.state.DrawOffer = ""
+	if accept {
+		connect.finish(&ctypes.Result{
+			WinnerColor: ctypes.NoColor,
+			Draw:        true,
+			Reason:      ctypes.ReasonAgreement,
+		})
 	}
+	return nil
+}
+
+func (connect *Connect4) abort() error {
+	if connect.state.GameOver {
+		return fmt.Errorf("Game is over")
+	}
+	if len(connect.state.History) > connect.firstPly {
+		return fmt.Errorf("Can only abort before the first move")
+	}
+	connect.finish(&ctypes.Result{
+		WinnerColor: ctypes.NoColor,
+		Reason:      ctypes.ReasonAborted,
+	})
+	return nil
 }
 
 func (connect *Connect4) sendUpdates() {
 	update := connect.marshalState()
+	connect.logUpdate(update)
 	for _, info := range connect.players {
-		info.UpdateChan <- update
+		deliver(info.UpdateChan, update)
+	}
+	for _, updates := range connect.spectators {
+		deliver(updates, update)
+	}
+	if connect.finished != nil && connect.finished.Updates == nil {
+		connect.finished.Updates = append([][]byte{}, connect.updateLog...)
+	}
+}
+
+// deliver queues update without blocking, so a client that stops reading can't stall the game while it holds
+// its lock. Every update is the whole state, so when the queue is full the oldest one is dropped to make room.
+func deliver(updates chan []byte, update []byte) {
+	for {
+		select {
+		case updates <- update:
+			return
+		default:
+		}
+		select {
+		case <-updates:
+		default:
+		}
+	}
+}
+
+// runFinishHooks hands a finished game to the finish hooks, which write to the database, once the game's
+// lock has been released.
+func (connect *Connect4) runFinishHooks() {
+	connect.mutex.Lock()
+	record := connect.finished
+	if record == nil || record.Updates == nil {
+		connect.mutex.Unlock()
+		return
+	}
+	connect.finished = nil
+	hooks := append([]FinishHook{}, connect.finishHooks...)
+	connect.mutex.Unlock()
+	fmt.Printf("GAME OVER: %+v\n", record.Result)
+	for _, hook := range hooks {
+		hook(record)
+	}
+}
+
+func (connect *Connect4) logUpdate(update []byte) {
+	if len(connect.updateLog) > 0 && bytes.Equal(connect.updateLog[len(connect.updateLog)-1], update) {
+		return
 	}
+	connect.updateLog = append(connect.updateLog, update)
 }
 
 func (connect *Connect4) makeMove(piece ctypes.Color, col int) error {
 	if piece != connect.state.CurrentTurn {
 		return fmt.Errorf("Not the correct turn.")
 	}
-	if col >= ctypes.Width || col < 0 {
+	if col >= connect.state.Width || col < 0 {
 		return fmt.Errorf("Not a legitimate move")
 	}
-	if len(connect.state.Columns[col]) >= ctypes.Height {
+	if len(connect.state.Columns[col]) >= connect.state.Height {
 		return fmt.Errorf("Column Full")
 	}
 	if connect.state.GameOver {
@@ -96,24 +424,136 @@ func (connect *Connect4) makeMove(piece ctypes.Color, col int) error {
 	lastTurn := connect.state.CurrentTurn
 	connect.state.CurrentTurn = ctypes.Black - connect.state.CurrentTurn
 	connect.state.Columns[col] = append(connect.state.Columns[col], piece)
+	connect.played(piece, col, false)
 	winCheck := connect.winCheck(col)
 	if winCheck != nil {
-		player := connect.playerByPieceColor(lastTurn)
-		fmt.Println("WINNER:", player)
-		connect.state.GameOver = true
 		connect.state.WinningPositions = winCheck
+		connect.win(lastTurn, ctypes.ReasonConnected)
+	} else {
+		connect.drawCheck()
 	}
 	return nil
 }
 
+// popPiece removes piece's own disc from the bottom of col under the Pop Out rules, dropping the rest of the column.
+// A pop can complete lines anywhere in the column for either color, so the whole board is rechecked. If both
+// colors end up connected, the player who popped wins.
+func (connect *Connect4) popPiece(piece ctypes.Color, col int) error {
+	if piece != connect.state.CurrentTurn {
+		return fmt.Errorf("Not the correct turn.")
+	}
+	if !connect.state.PopOut {
+		return fmt.Errorf("Pop Out is not enabled")
+	}
+	if col >= connect.state.Width || col < 0 {
+		return fmt.Errorf("Not a legitimate move")
+	}
+	if len(connect.state.Columns[col]) == 0 || connect.state.Columns[col][0] != piece {
+		return fmt.Errorf("Can only pop your own piece")
+	}
+	if connect.state.GameOver {
+		return nil
+	}
+	opponent := ctypes.Black - piece
+	connect.state.CurrentTurn = opponent
+	connect.state.Columns[col] = append([]ctypes.Color{}, connect.state.Columns[col][1:]...)
+	connect.played(piece, col, true)
+	if line := connect.lineFor(piece); line != nil {
+		connect.state.WinningPositions = line
+		connect.win(piece, ctypes.ReasonConnected)
+	} else if line := connect.lineFor(opponent); line != nil {
+		connect.state.WinningPositions = line
+		connect.win(opponent, ctypes.ReasonConnected)
+	} else {
+		connect.drawCheck()
+	}
+	return nil
+}
+
+// drawCheck ends the game in a draw when the player to move has nothing legal to play.
+func (connect *Connect4) drawCheck() {
+	if connect.hasLegalMove(connect.state.CurrentTurn) {
+		return
+	}
+	connect.finish(&ctypes.Result{
+		WinnerColor: ctypes.NoColor,
+		Draw:        true,
+		Reason:      ctypes.ReasonBoardFull,
+	})
+}
+
+func (connect *Connect4) win(winner ctypes.Color, reason ctypes.Reason) {
+	connect.finish(&ctypes.Result{
+		WinnerId:    connect.playerByPieceColor(winner),
+		WinnerColor: winner,
+		Reason:      reason,
+	})
+}
+
+func (connect *Connect4) finish(result *ctypes.Result) {
+	if connect.clock != nil {
+		connect.clock.stop(time.Now())
+	}
+	connect.state.GameOver = true
+	connect.state.Result = result
+	if connect.series != nil {
+		connect.series.Record(result)
+	}
+	record := &ctypes.GameRecord{
+		Options:   connect.state.Options,
+		Players:   map[string]ctypes.Color{},
+		History:   append([]ctypes.MoveRecord{}, connect.state.History...),
+		Result:    *result,
+		StartedAt: connect.startedAt,
+		EndedAt:   time.Now(),
+		FirstPly:  connect.firstPly,
+	}
+	if connect.series != nil {
+		record.SeriesGame = connect.series.Game
+	}
+	for id, info := range connect.players {
+		record.Players[id] = info.PlayerColor
+	}
+	connect.finished = record
+}
+
+func (connect *Connect4) AddFinishHook(hook FinishHook) {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	connect.finishHooks = append(connect.finishHooks, hook)
+}
+
+func (connect *Connect4) hasLegalMove(piece ctypes.Color) bool {
+	for _, col := range connect.state.Columns {
+		if len(col) < connect.state.Height {
+			return true
+		}
+		if connect.state.PopOut && len(col) > 0 && col[0] == piece {
+			return true
+		}
+	}
+	return false
+}
+
 func (connect *Connect4) marshalState() []byte {
 	state := &ctypes.UpdateGameState{
-		GameState: connect.state,
-		Players:   map[string]ctypes.Color{},
+		GameState:  connect.state,
+		Players:    map[string]ctypes.Color{},
+		Spectators: []string{},
+	}
+	state.Notation, _ = ctypes.Notation(connect.state.History)
+	if connect.clock != nil {
+		state.Remaining = connect.clock.snapshot(time.Now())
 	}
+	state.Series = connect.series
+	state.FirstPly = connect.firstPly
 	for player, info := range connect.players {
 		state.Players[player] = info.PlayerColor
 	}
+	for spectator := range connect.spectators {
+		state.Spectators = append(state.Spectators, spectator)
+	}
+	sort.Strings(state.Spectators)
 	stateJson, _ := json.Marshal(state)
 	return stateJson
 }
@@ -122,34 +562,88 @@ func (connect *Connect4) Close() {
 	close(connect.moveChannel)
 }
 
+func (connect *Connect4) Finished() bool {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	return connect.state.GameOver
+}
+
 func (connect *Connect4) Join(playerId string) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
 	if _, ok := connect.players[playerId]; ok {
 		fmt.Println("PLAYER " + playerId + " ALREADY IN GAME")
-	} else if len(connect.players) >= 2 {
+	} else if _, ok := connect.spectators[playerId]; ok {
+		return fmt.Errorf("Spectator %s can't join the game", playerId)
+	} else if seat := connect.freeSeat(); seat == ctypes.NoColor {
 		fmt.Println("PLAYER" + playerId + " CAN'T JOIN GAME, GAME FILLED.")
 		return fmt.Errorf("Game Filled")
 	} else {
 		fmt.Println("PLAYER " + playerId + " JOINED GAME")
+		connect.seats[seat] = playerId
 		connect.players[playerId] = &ctypes.PlayerInfo{
-			PlayerColor: ctypes.Color(len(connect.players)),
+			PlayerColor: seat,
 			UpdateChan:  make(chan []byte, 16),
 		}
+		if connect.clock != nil && len(connect.players) == 2 {
+			// Everyone needs to know the clock has started, not just whoever joined.
+			connect.startClock()
+			connect.sendUpdates()
+			return nil
+		}
 	}
 	update := connect.marshalState()
-	connect.players[playerId].UpdateChan <- update
+	connect.logUpdate(update)
+	deliver(connect.players[playerId].UpdateChan, update)
 	return nil
 }
 
 func (connect *Connect4) Leave(playerId string) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
 	fmt.Println("PLAYER " + playerId + " LEFT GAME")
+	if info, ok := connect.players[playerId]; ok {
+		connect.seats[info.PlayerColor] = ""
+	}
 	delete(connect.players, playerId)
 	return nil
 }
 
+func (connect *Connect4) Spectate(spectatorId string) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	if _, ok := connect.players[spectatorId]; ok {
+		return fmt.Errorf("Player %s is already playing", spectatorId)
+	}
+	fmt.Println("SPECTATOR " + spectatorId + " WATCHING GAME")
+	connect.spectators[spectatorId] = make(chan []byte, 16)
+	connect.sendUpdates()
+	return nil
+}
+
+func (connect *Connect4) StopSpectating(spectatorId string) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	updates, ok := connect.spectators[spectatorId]
+	if !ok {
+		return fmt.Errorf("No spectator in game with id %s", spectatorId)
+	}
+	fmt.Println("SPECTATOR " + spectatorId + " STOPPED WATCHING")
+	delete(connect.spectators, spectatorId)
+	close(updates)
+	connect.sendUpdates()
+	return nil
+}
+
 func (connect *Connect4) UpdatesChannel(playerId string) (<-chan []byte, error) {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
 	if info, ok := connect.players[playerId]; ok {
 		return info.UpdateChan, nil
 	}
+	if updates, ok := connect.spectators[playerId]; ok {
+		return updates, nil
+	}
 	return nil, fmt.Errorf("No player in game with id %s", playerId)
 }
 
@@ -158,7 +652,7 @@ func (connect *Connect4) MovesChannel(playerId string) (chan<- *types.Move, erro
 }
 
 func (connect *Connect4) winCheck(lastPlayed int) []ctypes.Position {
-	if lastPlayed < 0 || lastPlayed >= ctypes.Width {
+	if lastPlayed < 0 || lastPlayed >= connect.state.Width {
 		return nil
 	}
 	winCheck := connect.verticalCheck(lastPlayed)
@@ -172,13 +666,41 @@ func (connect *Connect4) winCheck(lastPlayed int) []ctypes.Position {
 	return connect.diagonalCheck(lastPlayed)
 }
 
+// lineFor scans the whole board for a winning line of piece.
+func (connect *Connect4) lineFor(piece ctypes.Color) []ctypes.Position {
+	n := connect.state.Connect
+	for _, delta := range [][2]int{{1, 0}, {0, 1}, {1, 1}, {-1, 1}} {
+		for col, column := range connect.state.Columns {
+			for row := range column {
+				line := []ctypes.Position{}
+				for i := 0; i < n; i += 1 {
+					c := col + i*delta[0]
+					r := row + i*delta[1]
+					if c < 0 || c >= connect.state.Width || r >= len(connect.state.Columns[c]) || connect.state.Columns[c][r] != piece {
+						break
+					}
+					line = append(line, ctypes.Position{
+						Row: r,
+						Col: c,
+					})
+				}
+				if len(line) == n {
+					return line
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (connect *Connect4) verticalCheck(lastPlayed int) []ctypes.Position {
+	n := connect.state.Connect
 	colLen := len(connect.state.Columns[lastPlayed])
-	if colLen < 4 {
+	if colLen < n {
 		return nil
 	}
 	lastPiece := connect.state.Columns[lastPlayed][colLen-1]
-	top := connect.state.Columns[lastPlayed][colLen-4:]
+	top := connect.state.Columns[lastPlayed][colLen-n:]
 	win := true
 	for _, piece := range top {
 		if piece != lastPiece {
@@ -187,7 +709,7 @@ func (connect *Connect4) verticalCheck(lastPlayed int) []ctypes.Position {
 	}
 	if win {
 		toret := []ctypes.Position{}
-		for i := colLen - 4; i < colLen; i += 1 {
+		for i := colLen - n; i < colLen; i += 1 {
 			toret = append(toret, ctypes.Position{
 				Col: lastPlayed,
 				Row: i,
@@ -199,22 +721,24 @@ func (connect *Connect4) verticalCheck(lastPlayed int) []ctypes.Position {
 }
 
 func (connect *Connect4) horizontalCheck(lastPlayed int) []ctypes.Position {
+	n := connect.state.Connect
+	width := connect.state.Width
 	colLen := len(connect.state.Columns[lastPlayed])
 	lastPiece := connect.state.Columns[lastPlayed][colLen-1]
-	minC := lastPlayed - 3
+	minC := lastPlayed - (n - 1)
 	if minC < 0 {
 		minC = 0
 	}
-	maxC := lastPlayed + 3
-	if maxC >= ctypes.Width {
-		maxC = ctypes.Width - 1
+	maxC := lastPlayed + (n - 1)
+	if maxC >= width {
+		maxC = width - 1
 	}
 	for col := minC; col <= lastPlayed; col += 1 {
-		if col+3 > maxC {
+		if col+(n-1) > maxC {
 			return nil
 		}
 		win := true
-		for colC := col; colC < col+4; colC += 1 {
+		for colC := col; colC < col+n; colC += 1 {
 			if len(connect.state.Columns[colC]) < colLen || connect.state.Columns[colC][colLen-1] != lastPiece {
 				win = false
 				break
@@ -222,7 +746,7 @@ func (connect *Connect4) horizontalCheck(lastPlayed int) []ctypes.Position {
 		}
 		if win {
 			toret := []ctypes.Position{}
-			for colC := col; colC < col+4; colC += 1 {
+			for colC := col; colC < col+n; colC += 1 {
 				toret = append(toret, ctypes.Position{
 					Row: colLen - 1,
 					Col: colC,
@@ -235,20 +759,23 @@ func (connect *Connect4) horizontalCheck(lastPlayed int) []ctypes.Position {
 }
 
 func (connect *Connect4) diagonalCheck(pieceCol int) []ctypes.Position {
+	n := connect.state.Connect
+	width := connect.state.Width
+	height := connect.state.Height
 	pieceRow := len(connect.state.Columns[pieceCol]) - 1
 	lastPiece := connect.state.Columns[pieceCol][pieceRow]
-	colLeft := pieceCol - 3
-	rowBot := pieceRow - 3
-	rowTop := pieceRow + 3
-	for i := 0; i < 7; i += 1 {
+	colLeft := pieceCol - (n - 1)
+	rowBot := pieceRow - (n - 1)
+	rowTop := pieceRow + (n - 1)
+	for i := 0; i < 2*n-1; i += 1 {
 		row_i := rowBot + i
 		col_i := colLeft + i
-		if row_i < 0 || col_i < 0 || row_i >= ctypes.Height || col_i >= ctypes.Width {
+		if row_i < 0 || col_i < 0 || row_i >= height || col_i >= width {
 			continue
 		}
 		win := true
-		for check := 0; check < 4; check += 1 {
-			if row_i+check >= ctypes.Height || col_i+check >= ctypes.Width {
+		for check := 0; check < n; check += 1 {
+			if row_i+check >= height || col_i+check >= width {
 				win = false
 				break
 			}
@@ -265,7 +792,7 @@ func (connect *Connect4) diagonalCheck(pieceCol int) []ctypes.Position {
 		}
 		if win {
 			toret := []ctypes.Position{}
-			for check := 0; check < 4; check += 1 {
+			for check := 0; check < n; check += 1 {
 				toret = append(toret, ctypes.Position{
 					Row: row_i + check,
 					Col: col_i + check,
@@ -274,15 +801,15 @@ func (connect *Connect4) diagonalCheck(pieceCol int) []ctypes.Position {
 			return toret
 		}
 	}
-	for i := 0; i < 7; i += 1 {
+	for i := 0; i < 2*n-1; i += 1 {
 		row_i := rowTop - i
 		col_i := colLeft + i
-		if row_i < 0 || col_i < 0 || row_i >= ctypes.Height || col_i >= ctypes.Width {
+		if row_i < 0 || col_i < 0 || row_i >= height || col_i >= width {
 			continue
 		}
 		win := true
-		for check := 0; check < 4; check += 1 {
-			if row_i-check < 0 || col_i+check >= ctypes.Width {
+		for check := 0; check < n; check += 1 {
+			if row_i-check < 0 || col_i+check >= width {
 				win = false
 				break
 			}
@@ -299,7 +826,7 @@ func (connect *Connect4) diagonalCheck(pieceCol int) []ctypes.Position {
 		}
 		if win {
 			toret := []ctypes.Position{}
-			for check := 0; check < 4; check += 1 {
+			for check := 0; check < n; check += 1 {
 				toret = append(toret, ctypes.Position{
 					Row: row_i - check,
 					Col: col_i + check,
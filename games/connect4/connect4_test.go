@@ -0,0 +1,483 @@
+package connect4
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"websockets/games"
+	ctypes "websockets/games/connect4/types"
+	"websockets/games/types"
+)
+
+func drain(updates <-chan []byte, stop <-chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case _, ok := <-updates:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+func TestConcurrentJoinsMovesAndLeaves(t *testing.T) {
+	game := NewConnect4(ctypes.DefaultOptions())
+	defer game.Close()
+
+	var joinWg sync.WaitGroup
+	joined := make(chan string, 10)
+	for i := 0; i < 10; i += 1 {
+		joinWg.Add(1)
+		go func(playerId string) {
+			defer joinWg.Done()
+			if game.Join(playerId) == nil {
+				joined <- playerId
+			}
+		}(fmt.Sprintf("player%d", i))
+	}
+	joinWg.Wait()
+	close(joined)
+	players := []string{}
+	for playerId := range joined {
+		players = append(players, playerId)
+	}
+	if len(players) != 2 {
+		t.Fatalf("expected 2 players to join, got %d", len(players))
+	}
+
+	stop := make(chan bool)
+	var drainWg sync.WaitGroup
+	for _, playerId := range players {
+		updates, err := game.UpdatesChannel(playerId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drainWg.Add(1)
+		go drain(updates, stop, &drainWg)
+	}
+
+	var wg sync.WaitGroup
+	for _, playerId := range players {
+		wg.Add(1)
+		go func(playerId string) {
+			defer wg.Done()
+			moves, _ := game.MovesChannel(playerId)
+			for i := 0; i < 200; i += 1 {
+				data := fmt.Sprintf(`{"Col": %d}`, i%7)
+				if i%50 == 49 {
+					data = `{"Rematch": true}`
+				}
+				moves <- &types.Move{PlayerId: playerId, Data: []byte(data)}
+				game.Finished()
+			}
+		}(playerId)
+	}
+	for i := 0; i < 5; i += 1 {
+		wg.Add(1)
+		go func(spectatorId string) {
+			defer wg.Done()
+			if err := game.Spectate(spectatorId); err != nil {
+				t.Error(err)
+				return
+			}
+			updates, err := game.UpdatesChannel(spectatorId)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			drainWg.Add(1)
+			go drain(updates, stop, &drainWg)
+			if err := game.StopSpectating(spectatorId); err != nil {
+				t.Error(err)
+			}
+		}(fmt.Sprintf("spectator%d", i))
+	}
+	wg.Wait()
+
+	for _, playerId := range players {
+		game.Leave(playerId)
+	}
+	close(stop)
+	drainWg.Wait()
+}
+
+func TestSpectatorCantJoin(t *testing.T) {
+	game := NewConnect4(ctypes.DefaultOptions())
+	defer game.Close()
+	if err := game.Spectate("watcher"); err != nil {
+		t.Fatal(err)
+	}
+	if err := game.Join("watcher"); err == nil {
+		t.Error("expected a spectator to be refused a seat")
+	}
+}
+
+func TestFullBoardIsDraw(t *testing.T) {
+	game := NewConnect4(ctypes.DefaultOptions())
+	defer game.Close()
+	// Pairs of rows alternate colors, with the middle column inverted, so no four line up anywhere.
+	for col := 0; col < game.state.Width; col += 1 {
+		for row := 0; row < game.state.Height; row += 1 {
+			piece := ctypes.Color((row / 2) % 2)
+			if col == 3 {
+				piece = ctypes.Black - piece
+			}
+			game.state.Columns[col] = append(game.state.Columns[col], piece)
+		}
+	}
+	last := len(game.state.Columns[6]) - 1
+	game.state.CurrentTurn = game.state.Columns[6][last]
+	game.state.Columns[6] = game.state.Columns[6][:last]
+
+	if err := game.makeMove(game.state.CurrentTurn, 6); err != nil {
+		t.Fatal(err)
+	}
+	result := game.state.Result
+	if !game.state.GameOver || result == nil || !result.Draw || result.Reason != ctypes.ReasonBoardFull {
+		t.Errorf("expected a draw, got %+v", game.state)
+	}
+}
+
+func TestWinResult(t *testing.T) {
+	game := NewConnect4(ctypes.DefaultOptions())
+	defer game.Close()
+	game.Join("red")
+	game.Join("black")
+	for _, col := range []int{0, 1, 0, 1, 0, 1, 0} {
+		if err := game.makeMove(game.state.CurrentTurn, col); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result := game.state.Result
+	if result == nil || result.WinnerId != "red" || result.WinnerColor != ctypes.Red || result.Reason != ctypes.ReasonConnected {
+		t.Errorf("expected red to win with four in a row, got %+v", result)
+	}
+}
+
+func TestConnectFiveOnLargerBoard(t *testing.T) {
+	game := NewConnect4(ctypes.Options{Width: 8, Height: 7, Connect: 5})
+	defer game.Close()
+	game.Join("red")
+	game.Join("black")
+	for _, col := range []int{7, 0, 7, 0, 7, 0, 7, 0} {
+		if err := game.makeMove(game.state.CurrentTurn, col); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if game.state.GameOver {
+		t.Fatalf("four in a row shouldn't win Connect-5, got %+v", game.state.Result)
+	}
+	if err := game.makeMove(game.state.CurrentTurn, 7); err != nil {
+		t.Fatal(err)
+	}
+	result := game.state.Result
+	if result == nil || result.WinnerId != "red" || len(game.state.WinningPositions) != 5 {
+		t.Errorf("expected red to win with five in a row, got %+v", result)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	options, err := ctypes.ParseOptions([]byte(`{"Width": 8, "Connect": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Width != 8 || options.Height != ctypes.DefaultHeight || options.Connect != 5 {
+		t.Errorf("unexpected options %+v", options)
+	}
+	if _, err := ctypes.ParseOptions([]byte(`{"Width": 5, "Height": 5, "Connect": 6}`)); err == nil {
+		t.Error("expected a win length that doesn't fit the board to be rejected")
+	}
+	if _, err := ctypes.ParseOptions([]byte(`{"TimeControl": {"Kind": "Fischer", "Increment": 1000}}`)); err == nil {
+		t.Error("expected a Fischer clock with no starting time to be rejected")
+	}
+	if _, err := ctypes.ParseOptions([]byte(`{"BestOf": 4}`)); err == nil {
+		t.Error("expected a series of an even number of games to be rejected")
+	}
+}
+
+func newPopOutGame(t *testing.T) *Connect4 {
+	options := ctypes.DefaultOptions()
+	options.PopOut = true
+	game := NewConnect4(options)
+	game.Join("red")
+	game.Join("black")
+	return game
+}
+
+func TestPopOut(t *testing.T) {
+	game := newPopOutGame(t)
+	defer game.Close()
+	for _, col := range []int{0, 0, 1} {
+		if err := game.makeMove(game.state.CurrentTurn, col); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := game.popPiece(ctypes.Black, 1); err == nil {
+		t.Error("expected popping the opponent's piece to be rejected")
+	}
+	if err := game.popPiece(ctypes.Black, 0); err == nil {
+		t.Error("expected popping from under the opponent's piece to be rejected")
+	}
+	game.state.Columns[0] = []ctypes.Color{ctypes.Black, ctypes.Red}
+	if err := game.popPiece(ctypes.Black, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(game.state.Columns[0]) != 1 || game.state.Columns[0][0] != ctypes.Red || game.state.CurrentTurn != ctypes.Red {
+		t.Errorf("expected the column to drop after a pop, got %+v", game.state)
+	}
+
+	standard := NewConnect4(ctypes.DefaultOptions())
+	defer standard.Close()
+	standard.makeMove(ctypes.Red, 0)
+	standard.makeMove(ctypes.Black, 1)
+	if err := standard.popPiece(ctypes.Red, 0); err == nil {
+		t.Error("expected pops to be rejected without Pop Out")
+	}
+}
+
+func TestPopConnectingBothColorsWinsForPopper(t *testing.T) {
+	game := newPopOutGame(t)
+	defer game.Close()
+	// Popping column 3 drops red's fourth piece into row 0 and black's into row 1 at once.
+	game.state.Columns[0] = []ctypes.Color{ctypes.Red, ctypes.Black}
+	game.state.Columns[1] = []ctypes.Color{ctypes.Red, ctypes.Black}
+	game.state.Columns[2] = []ctypes.Color{ctypes.Red, ctypes.Black}
+	game.state.Columns[3] = []ctypes.Color{ctypes.Black, ctypes.Red, ctypes.Black}
+	game.state.CurrentTurn = ctypes.Black
+	if err := game.popPiece(ctypes.Black, 3); err != nil {
+		t.Fatal(err)
+	}
+	result := game.state.Result
+	if result == nil || result.WinnerColor != ctypes.Black {
+		t.Errorf("expected black to win by popping, got %+v", result)
+	}
+}
+
+func TestHistoryAndNotation(t *testing.T) {
+	game, err := games.New("connect4", []byte(`{"Moves": "4453"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer game.Close()
+	connect := game.(*Connect4)
+	connect.Join("red")
+	connect.Join("black")
+	connect.makeMove(ctypes.Red, 6)
+
+	history := connect.state.History
+	if len(history) != 5 || history[4].PlayerId != "red" || history[4].Col != 6 {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	notation, err := ctypes.Notation(history)
+	if err != nil || notation != "44537" {
+		t.Errorf("expected notation 44537, got %q (%v)", notation, err)
+	}
+
+	if _, err := games.New("connect4", []byte(`{"Moves": "1111111"}`)); err == nil {
+		t.Error("expected a move into a full column to be rejected")
+	}
+	moves, err := ctypes.ParseNotation("4p4")
+	if err != nil || len(moves) != 2 || !moves[1].Pop || moves[1].Col != 3 {
+		t.Errorf("unexpected parse of a pop: %+v (%v)", moves, err)
+	}
+}
+
+func command(game *Connect4, playerId, data string) {
+	game.handleMove(&types.Move{PlayerId: playerId, Data: []byte(data)})
+}
+
+func newTwoPlayerGame() *Connect4 {
+	game := NewConnect4(ctypes.DefaultOptions())
+	game.Join("red")
+	game.Join("black")
+	return game
+}
+
+func TestResign(t *testing.T) {
+	game := newTwoPlayerGame()
+	defer game.Close()
+	command(game, "red", `{"Col": 3}`)
+	// Resigning doesn't have to wait for your turn.
+	command(game, "red", `{"Resign": true}`)
+	result := game.state.Result
+	if result == nil || result.WinnerId != "black" || result.Reason != ctypes.ReasonResignation {
+		t.Errorf("expected black to win by resignation, got %+v", result)
+	}
+}
+
+func TestDrawOffers(t *testing.T) {
+	game := newTwoPlayerGame()
+	defer game.Close()
+	command(game, "red", `{"OfferDraw": true}`)
+	command(game, "red", `{"AcceptDraw": true}`)
+	if game.state.GameOver || game.state.DrawOffer != "red" {
+		t.Fatalf("players can't accept their own offers, got %+v", game.state.Result)
+	}
+	command(game, "black", `{"DeclineDraw": true}`)
+	if game.state.DrawOffer != "" {
+		t.Fatal("declining should withdraw the offer")
+	}
+
+	// Offers stand through the offerer's own move, but moving in reply declines them.
+	command(game, "red", `{"OfferDraw": true}`)
+	command(game, "red", `{"Col": 0}`)
+	if game.state.DrawOffer != "red" {
+		t.Fatal("the offerer's move shouldn't withdraw the offer")
+	}
+	command(game, "black", `{"Col": 0}`)
+	if game.state.DrawOffer != "" {
+		t.Fatal("moving should decline the offer")
+	}
+
+	command(game, "black", `{"OfferDraw": true}`)
+	command(game, "red", `{"OfferDraw": true}`)
+	result := game.state.Result
+	if result == nil || !result.Draw || result.Reason != ctypes.ReasonAgreement {
+		t.Errorf("crossed offers should agree a draw, got %+v", result)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	game := newTwoPlayerGame()
+	defer game.Close()
+	command(game, "red", `{"Col": 3}`)
+	command(game, "black", `{"Abort": true}`)
+	if game.state.GameOver {
+		t.Fatal("aborted after the first move")
+	}
+
+	fresh := newTwoPlayerGame()
+	defer fresh.Close()
+	fresh.importMoves("44")
+	update := &ctypes.UpdateGameState{}
+	if err := json.Unmarshal(fresh.marshalState(), update); err != nil || update.FirstPly != 2 {
+		t.Fatalf("clients need to know the imported moves can still be aborted, got %+v (%v)", update, err)
+	}
+	command(fresh, "black", `{"Abort": true}`)
+	result := fresh.state.Result
+	if result == nil || result.Draw || result.WinnerColor != ctypes.NoColor || result.Reason != ctypes.ReasonAborted {
+		t.Errorf("expected the game to be aborted, got %+v", result)
+	}
+}
+
+func TestRejoinTakesFreeSeat(t *testing.T) {
+	game := newTwoPlayerGame()
+	defer game.Close()
+	game.Leave("red")
+	if err := game.Join("substitute"); err != nil {
+		t.Fatal(err)
+	}
+	if color := game.players["substitute"].PlayerColor; color != ctypes.Red {
+		t.Errorf("expected the substitute to take the empty red seat, got %d", color)
+	}
+	if err := game.Join("another"); err == nil {
+		t.Error("expected a third player to be turned away")
+	}
+}
+
+func TestRandomColors(t *testing.T) {
+	options := ctypes.DefaultOptions()
+	options.RandomColors = true
+	for i := 0; i < 10; i += 1 {
+		game := NewConnect4(options)
+		game.Join("a")
+		game.Join("b")
+		if game.players["a"].PlayerColor == game.players["b"].PlayerColor {
+			t.Fatalf("both players were given color %d", game.players["a"].PlayerColor)
+		}
+		game.Close()
+	}
+}
+
+func rematch(game *Connect4) {
+	command(game, "red", `{"Rematch": true}`)
+	command(game, "black", `{"Rematch": true}`)
+}
+
+func TestRematchSwapsColors(t *testing.T) {
+	game := newTwoPlayerGame()
+	defer game.Close()
+	command(game, "red", `{"Resign": true}`)
+	rematch(game)
+	if game.players["black"].PlayerColor != ctypes.Red || game.players["red"].PlayerColor != ctypes.Black {
+		t.Fatal("expected the players to swap colors")
+	}
+	command(game, "black", `{"Col": 3}`)
+	if len(game.state.History) != 1 || game.state.History[0].PlayerId != "black" {
+		t.Errorf("expected black to move first after the swap, got %+v", game.state.History)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	options := ctypes.DefaultOptions()
+	options.BestOf = 3
+	game := NewConnect4(options)
+	defer game.Close()
+	game.Join("red")
+	game.Join("black")
+
+	command(game, "red", `{"Resign": true}`)
+	rematch(game)
+	command(game, "black", `{"Abort": true}`)
+	rematch(game)
+	if game.series.Game != 2 || game.series.Wins["black"] != 1 {
+		t.Fatalf("aborted games shouldn't count, got %+v", game.series)
+	}
+	command(game, "red", `{"OfferDraw": true}`)
+	command(game, "black", `{"AcceptDraw": true}`)
+	rematch(game)
+	if game.series.Over {
+		t.Fatal("series ended with a game left that could tie it")
+	}
+	command(game, "red", `{"Resign": true}`)
+	if !game.series.Over || game.series.WinnerId != "black" || game.series.Wins["black"] != 2 || game.series.Draws != 1 {
+		t.Fatalf("expected black to take the series, got %+v", game.series)
+	}
+	if game.finished == nil || game.finished.SeriesGame != 3 {
+		t.Errorf("expected the last game to be recorded as game 3 of the series, got %+v", game.finished)
+	}
+	rematch(game)
+	if game.series.Over || game.series.Game != 1 || len(game.series.Wins) != 0 {
+		t.Errorf("expected a rematch to start a new series, got %+v", game.series)
+	}
+}
+
+// A player who stops reading mustn't hold up the game, and finish hooks run without the game's lock, so they
+// can ask about the game themselves.
+func TestStalledPlayerDoesntBlockGame(t *testing.T) {
+	game := newTwoPlayerGame()
+	defer game.Close()
+	hooked := make(chan bool, 1)
+	game.AddFinishHook(func(record *ctypes.GameRecord) {
+		hooked <- game.Finished()
+	})
+	moves, _ := game.MovesChannel("red")
+	// Nobody reads either player's updates through more moves than their channels hold, and then a resignation.
+	players := []string{"red", "black"}
+	for ply := 0; ply < 20; ply += 1 {
+		moves <- &types.Move{PlayerId: players[ply%2], Data: []byte(fmt.Sprintf(`{"Col": %d}`, ply%7))}
+	}
+	moves <- &types.Move{PlayerId: "red", Data: []byte(`{"Resign": true}`)}
+	select {
+	case finished := <-hooked:
+		if !finished {
+			t.Error("the finish hook should see the game over")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the game stalled behind a player who stopped reading")
+	}
+	updates, _ := game.UpdatesChannel("red")
+	var last []byte
+	for len(updates) > 0 {
+		last = <-updates
+	}
+	if !bytes.Equal(last, game.marshalState()) {
+		t.Error("the newest update should be kept when old ones are dropped")
+	}
+}
@@ -0,0 +1,137 @@
+package connect4
+
+import (
+	"flag"
+	"math/rand"
+	"reflect"
+	"testing"
+	ctypes "websockets/games/connect4/types"
+	"websockets/games/connect4/types/internalstate"
+)
+
+// Run with e.g. -crosscheck.games 1000000 for a thorough check.
+var (
+	crossCheckGames = flag.Int("crosscheck.games", 10000, "random games TestCrossCheckEngines plays")
+	crossCheckSeed  = flag.Int64("crosscheck.seed", 1, "seed for TestCrossCheckEngines")
+)
+
+func randomOptions(random *rand.Rand) ctypes.Options {
+	options := ctypes.Options{
+		Width:   4 + random.Intn(6),
+		Height:  4 + random.Intn(6),
+		Connect: 2 + random.Intn(5),
+		PopOut:  random.Intn(3) == 0,
+	}
+	if options.Connect > options.Width && options.Connect > options.Height {
+		options.Connect = options.Width
+	}
+	return options
+}
+
+// serverMoves lists the legal moves on the server's board, numbered the way the engines number them.
+func serverMoves(connect *Connect4) []int {
+	toret := []int{}
+	for col, column := range connect.state.Columns {
+		if len(column) < connect.state.Height {
+			toret = append(toret, col)
+		}
+	}
+	if connect.state.PopOut {
+		for col, column := range connect.state.Columns {
+			if len(column) > 0 && column[0] == connect.state.CurrentTurn {
+				toret = append(toret, connect.state.Width+col)
+			}
+		}
+	}
+	return toret
+}
+
+// serverPlay makes move on the server's board with makeMove or popPiece, and returns the winner they find,
+// or NoColor.
+func serverPlay(t testing.TB, connect *Connect4, move int) ctypes.Color {
+	t.Helper()
+	piece := connect.state.CurrentTurn
+	var err error
+	if move < connect.state.Width {
+		err = connect.makeMove(piece, move)
+	} else {
+		err = connect.popPiece(piece, move-connect.state.Width)
+	}
+	if err != nil {
+		t.Fatalf("the server rejected move %d, which it listed as legal: %v", move, err)
+	}
+	result := connect.state.Result
+	if result == nil || result.Draw {
+		return ctypes.NoColor
+	}
+	checkLine(t, connect, connect.state.WinningPositions, result.WinnerColor)
+	return result.WinnerColor
+}
+
+// checkLine fails the test unless line is Connect of winner's pieces in a straight line.
+func checkLine(t testing.TB, connect *Connect4, line []ctypes.Position, winner ctypes.Color) {
+	t.Helper()
+	if len(line) != connect.state.Connect {
+		t.Fatalf("winning line %v isn't %d long", line, connect.state.Connect)
+	}
+	for i, position := range line {
+		column := connect.state.Columns[position.Col]
+		if position.Row >= len(column) || column[position.Row] != winner {
+			t.Fatalf("winning line %v has a square that isn't color %d", line, winner)
+		}
+		if i > 1 && (position.Col-line[i-1].Col != line[1].Col-line[0].Col || position.Row-line[i-1].Row != line[1].Row-line[0].Row) {
+			t.Fatalf("winning line %v isn't straight", line)
+		}
+	}
+}
+
+// TestCrossCheckEngines plays random games through the server's rules and both of the engines the agents
+// search with, which are separate implementations of the same rules, and checks they always agree on the
+// legal moves and the result.
+func TestCrossCheckEngines(t *testing.T) {
+	random := rand.New(rand.NewSource(*crossCheckSeed))
+	for game := 0; game < *crossCheckGames; game += 1 {
+		options := randomOptions(random)
+		connect := &Connect4{state: ctypes.NewGameState(options)}
+		update := &ctypes.UpdateGameState{GameState: ctypes.NewGameState(options)}
+		engines := []internalstate.Engine{internalstate.NewInternalState("", update)}
+		if internalstate.Fits(options.Width, options.Height) {
+			engines = append(engines, internalstate.NewBitboard("", update))
+		}
+		moves := []int{}
+		for ply := 0; ply < 200; ply += 1 {
+			legal := serverMoves(connect)
+			for _, engine := range engines {
+				if !reflect.DeepEqual(engine.GenerateMoves(), legal) {
+					t.Fatalf("%+v after %v: %T generates %v, the server allows %v", options, moves, engine, engine.GenerateMoves(), legal)
+				}
+			}
+			move := legal[random.Intn(len(legal))]
+			moves = append(moves, move)
+			winner := serverPlay(t, connect, move)
+			stalemate := !connect.hasLegalMove(connect.state.CurrentTurn)
+			for _, engine := range engines {
+				engine.MakeMove(move)
+				if engine.VictoryCheck() != int(winner) || engine.StalemateCheck() != stalemate {
+					t.Fatalf("%+v after %v: %T gives winner %d and stalemate %v, the server %d and %v", options, moves, engine, engine.VictoryCheck(), engine.StalemateCheck(), winner, stalemate)
+				}
+			}
+			if winner != ctypes.NoColor || stalemate {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkServerRandomGames(b *testing.B) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < b.N; i += 1 {
+		connect := &Connect4{state: ctypes.NewGameState(ctypes.DefaultOptions())}
+		for {
+			legal := serverMoves(connect)
+			if serverPlay(b, connect, legal[random.Intn(len(legal))]) != ctypes.NoColor || !connect.hasLegalMove(connect.state.CurrentTurn) {
+				break
+			}
+		}
+	}
+}
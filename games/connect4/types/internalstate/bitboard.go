@@ -0,0 +1,222 @@
+package internalstate
+
+import (
+	"math/bits"
+	ctypes "websockets/games/connect4/types"
+)
+
+// Bitboard is a position packed into one bit per cell, column by column from the bottom, with a spare
+// bit above each column so lines can't wrap from the top of one column into the next. Moves are made and
+// unmade in constant time, and lines are found with shifts rather than by scanning the board. It only
+// holds boards where Width * (Height + 1) leaves the top bit free, which is kept for the side to move
+// in Key; see Fits.
+type Bitboard struct {
+	stones  [2]uint64
+	heights [ctypes.MaxSize]int
+	Turn    int
+	Agent   int
+	Moves   []int
+	Width   int
+	Rows    int
+	Connect int
+	PopOut  bool
+	// colMasks has the cells of each column set, and bottom the lowest cell of every column.
+	colMasks [ctypes.MaxSize]uint64
+	bottom   uint64
+	// shifts are the distances between neighbouring cells vertically, horizontally and along both diagonals.
+	shifts  [4]uint
+	weights [64]int
+}
+
+// Fits reports whether a Bitboard can hold a board of the given size.
+func Fits(width, height int) bool {
+	return width*(height+1) < 64
+}
+
+func NewBitboard(agentId string, s *ctypes.UpdateGameState) *Bitboard {
+	stride := s.Height + 1
+	toret := &Bitboard{
+		Turn:    int(s.CurrentTurn),
+		Agent:   int(s.Players[agentId]),
+		Moves:   []int{},
+		Width:   s.Width,
+		Rows:    s.Height,
+		Connect: s.Connect,
+		PopOut:  s.PopOut,
+		shifts:  [4]uint{1, uint(stride), uint(stride - 1), uint(stride + 1)},
+	}
+	locScore := locationScores(s.Width, s.Height, s.Connect)
+	for col := 0; col < s.Width; col += 1 {
+		toret.colMasks[col] = ((1 << uint(s.Height)) - 1) << uint(col*stride)
+		toret.bottom |= 1 << uint(col*stride)
+		toret.heights[col] = len(s.Columns[col])
+		for row := 0; row < s.Height; row += 1 {
+			toret.weights[col*stride+row] = locScore[col][row]
+		}
+		for row, piece := range s.Columns[col] {
+			toret.stones[piece] |= toret.cell(col, row)
+		}
+	}
+	return toret
+}
+
+func (board *Bitboard) cell(col, row int) uint64 {
+	return 1 << uint(col*(board.Rows+1)+row)
+}
+
+// Moves are numbered as they are for InternalState.
+func (board *Bitboard) PopMove(col int) int {
+	return board.Width + col
+}
+
+func (board *Bitboard) IsPop(move int) bool {
+	return move >= board.Width
+}
+
+func (board *Bitboard) MoveColumn(move int) int {
+	if board.IsPop(move) {
+		return move - board.Width
+	}
+	return move
+}
+
+func (board *Bitboard) GenerateMoves() []int {
+	toret := make([]int, 0, 2*board.Width)
+	for col := 0; col < board.Width; col += 1 {
+		if board.heights[col] < board.Rows {
+			toret = append(toret, col)
+		}
+	}
+	if board.PopOut {
+		for col := 0; col < board.Width; col += 1 {
+			if board.stones[board.Turn]&board.colMasks[col]&board.bottom != 0 {
+				toret = append(toret, board.PopMove(col))
+			}
+		}
+	}
+	return toret
+}
+
+func (board *Bitboard) MakeMove(move int) {
+	col := board.MoveColumn(move)
+	if board.IsPop(move) {
+		board.shiftColumn(col, false)
+		board.heights[col] -= 1
+	} else {
+		board.stones[board.Turn] |= board.cell(col, board.heights[col])
+		board.heights[col] += 1
+	}
+	board.Turn = 1 - board.Turn
+	board.Moves = append(board.Moves, move)
+}
+
+func (board *Bitboard) UnmakeMove() {
+	move := board.Moves[len(board.Moves)-1]
+	col := board.MoveColumn(move)
+	board.Turn = 1 - board.Turn
+	if board.IsPop(move) {
+		board.shiftColumn(col, true)
+		board.stones[board.Turn] |= board.cell(col, 0)
+		board.heights[col] += 1
+	} else {
+		board.heights[col] -= 1
+		board.stones[board.Turn] &^= board.cell(col, board.heights[col])
+	}
+	board.Moves = board.Moves[:len(board.Moves)-1]
+}
+
+// shiftColumn moves both colors' pieces in col down a row, dropping the bottom one, or up a row to leave
+// the bottom empty.
+func (board *Bitboard) shiftColumn(col int, up bool) {
+	colMask := board.colMasks[col]
+	for color := range board.stones {
+		pieces := board.stones[color] & colMask
+		if up {
+			pieces <<= 1
+		} else {
+			pieces >>= 1
+		}
+		board.stones[color] = board.stones[color]&^colMask | pieces&colMask
+	}
+}
+
+// StalemateCheck reports a draw: the side to move has nothing to play.
+func (board *Bitboard) StalemateCheck() bool {
+	for col := 0; col < board.Width; col += 1 {
+		if board.heights[col] != board.Rows {
+			return false
+		}
+	}
+	return !board.PopOut || board.stones[board.Turn]&board.bottom == 0
+}
+
+// winFor reports whether color has Connect in a row. Each shift and AND leaves only the cells that start
+// a run one longer in that direction.
+func (board *Bitboard) winFor(color int) bool {
+	pieces := board.stones[color]
+	for _, shift := range board.shifts {
+		run := pieces
+		for i := 1; i < board.Connect && run != 0; i += 1 {
+			run &= pieces >> (uint(i) * shift)
+		}
+		if run != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// VictoryCheck returns the color with a line on the board, or -1. If both have one, which only a pop can
+// do, the player who just moved wins.
+func (board *Bitboard) VictoryCheck() int {
+	mover := 1 - board.Turn
+	if board.winFor(mover) {
+		return mover
+	}
+	if board.winFor(board.Turn) {
+		return board.Turn
+	}
+	return -1
+}
+
+// Key identifies the position. Adding the bottom row to the mask of occupied cells marks the first empty
+// cell of each column, and the side to move's pieces below it say whose each piece is.
+func (board *Bitboard) Key() uint64 {
+	occupied := board.stones[0] | board.stones[1]
+	toret := board.stones[board.Turn] + occupied + board.bottom
+	if board.Turn == ctypes.Black {
+		toret |= 1 << 63
+	}
+	return toret
+}
+
+func (board *Bitboard) ToMove() int {
+	return board.Turn
+}
+
+func (board *Bitboard) AgentColor() int {
+	return board.Agent
+}
+
+func (board *Bitboard) Ply() int {
+	return len(board.Moves)
+}
+
+func (board *Bitboard) Clone() Engine {
+	toret := *board
+	toret.Moves = append([]int{}, board.Moves...)
+	return &toret
+}
+
+func (board *Bitboard) Evaluate() int {
+	return board.weigh(board.stones[board.Agent]) - board.weigh(board.stones[1-board.Agent])
+}
+
+func (board *Bitboard) weigh(pieces uint64) int {
+	toret := 0
+	for pieces != 0 {
+		toret += board.weights[bits.TrailingZeros64(pieces)]
+		pieces &= pieces - 1
+	}
+	return toret
+}
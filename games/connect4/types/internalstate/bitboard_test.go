@@ -0,0 +1,170 @@
+package internalstate
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	ctypes "websockets/games/connect4/types"
+)
+
+func newEngines(options ctypes.Options) (*InternalState, *Bitboard) {
+	s := &ctypes.UpdateGameState{
+		GameState: ctypes.NewGameState(options),
+		Players:   map[string]ctypes.Color{"agent": ctypes.Black},
+	}
+	return NewInternalState("agent", s), NewBitboard("agent", s)
+}
+
+func sameEngineState(t *testing.T, is *InternalState, board *Bitboard) {
+	t.Helper()
+	if !reflect.DeepEqual(is.GenerateMoves(), board.GenerateMoves()) {
+		t.Fatalf("moves differ after %v: %v and %v", is.Moves, is.GenerateMoves(), board.GenerateMoves())
+	}
+	if is.VictoryCheck() != board.VictoryCheck() || is.StalemateCheck() != board.StalemateCheck() {
+		t.Fatalf("results differ after %v: %d/%v and %d/%v", is.Moves, is.VictoryCheck(), is.StalemateCheck(), board.VictoryCheck(), board.StalemateCheck())
+	}
+	if is.Evaluate() != board.Evaluate() || is.ToMove() != board.ToMove() || is.Ply() != board.Ply() {
+		t.Fatalf("evaluations differ after %v", is.Moves)
+	}
+}
+
+func TestBitboardMatchesInternalState(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, size := range [][3]int{{7, 6, 4}, {4, 4, 3}, {9, 5, 5}, {6, 8, 4}} {
+		for _, popOut := range []bool{false, true} {
+			options := ctypes.Options{Width: size[0], Height: size[1], Connect: size[2], PopOut: popOut}
+			for game := 0; game < 50; game += 1 {
+				is, board := newEngines(options)
+				keys := []uint64{}
+				for ply := 0; ply < 80; ply += 1 {
+					sameEngineState(t, is, board)
+					if is.VictoryCheck() >= 0 || is.StalemateCheck() {
+						break
+					}
+					keys = append(keys, board.Key())
+					moves := is.GenerateMoves()
+					move := moves[random.Intn(len(moves))]
+					is.MakeMove(move)
+					board.MakeMove(move)
+				}
+				for len(board.Moves) > 0 {
+					is.UnmakeMove()
+					board.UnmakeMove()
+					sameEngineState(t, is, board)
+					if key := board.Key(); key != keys[len(board.Moves)] {
+						t.Fatalf("unmaking back to ply %d gave key %x, expected %x", len(board.Moves), key, keys[len(board.Moves)])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestBitboardKeys(t *testing.T) {
+	_, a := newEngines(ctypes.DefaultOptions())
+	_, b := newEngines(ctypes.DefaultOptions())
+	for _, move := range []int{3, 2, 4} {
+		a.MakeMove(move)
+	}
+	for _, move := range []int{4, 2, 3} {
+		b.MakeMove(move)
+	}
+	if a.Key() != b.Key() {
+		t.Error("transposed moves should reach the same key")
+	}
+	b.UnmakeMove()
+	b.MakeMove(2)
+	if a.Key() == b.Key() {
+		t.Error("different positions should have different keys")
+	}
+
+	options := ctypes.DefaultOptions()
+	options.PopOut = true
+	_, popped := newEngines(options)
+	// Red pops its own piece back out, leaving black's piece alone with black to move.
+	for _, move := range []int{0, 1, popped.PopMove(0)} {
+		popped.MakeMove(move)
+	}
+	s := &ctypes.UpdateGameState{
+		GameState: ctypes.NewGameState(options),
+	}
+	s.Columns[1] = []ctypes.Color{ctypes.Black}
+	if NewBitboard("agent", s).Key() == popped.Key() {
+		t.Error("the side to move should be part of the key")
+	}
+	s.CurrentTurn = ctypes.Black
+	if NewBitboard("agent", s).Key() != popped.Key() {
+		t.Error("the same position should have the same key however it was reached")
+	}
+}
+
+func TestNewEngine(t *testing.T) {
+	s := &ctypes.UpdateGameState{
+		GameState: ctypes.NewGameState(ctypes.DefaultOptions()),
+	}
+	if _, ok := NewEngine("agent", s).(*Bitboard); !ok {
+		t.Error("expected a Bitboard for the standard board")
+	}
+	options := ctypes.DefaultOptions()
+	options.Width, options.Height = 8, 7
+	s.GameState = ctypes.NewGameState(options)
+	if _, ok := NewEngine("agent", s).(*InternalState); !ok {
+		t.Error("expected an InternalState for a board too big for a Bitboard")
+	}
+}
+
+// walk visits every position within depth moves, checking each for a result the way a search would,
+// and returns how many it visited.
+func walk(engine Engine, depth int) int {
+	if depth == 0 || engine.VictoryCheck() >= 0 || engine.StalemateCheck() {
+		return 1
+	}
+	toret := 1
+	for _, move := range engine.GenerateMoves() {
+		engine.MakeMove(move)
+		toret += walk(engine, depth-1)
+		engine.UnmakeMove()
+	}
+	return toret
+}
+
+func benchmarkWalk(b *testing.B, engine Engine) {
+	nodes := 0
+	for i := 0; i < b.N; i += 1 {
+		nodes += walk(engine, 5)
+	}
+	b.ReportMetric(float64(nodes)/b.Elapsed().Seconds(), "nodes/s")
+}
+
+func BenchmarkWalkInternalState(b *testing.B) {
+	is, _ := newEngines(ctypes.DefaultOptions())
+	benchmarkWalk(b, is)
+}
+
+func BenchmarkWalkBitboard(b *testing.B) {
+	_, board := newEngines(ctypes.DefaultOptions())
+	benchmarkWalk(b, board)
+}
+
+func benchmarkPlayouts(b *testing.B, engine Engine) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < b.N; i += 1 {
+		for engine.VictoryCheck() < 0 && !engine.StalemateCheck() {
+			moves := engine.GenerateMoves()
+			engine.MakeMove(moves[random.Intn(len(moves))])
+		}
+		for engine.Ply() > 0 {
+			engine.UnmakeMove()
+		}
+	}
+}
+
+func BenchmarkPlayoutInternalState(b *testing.B) {
+	is, _ := newEngines(ctypes.DefaultOptions())
+	benchmarkPlayouts(b, is)
+}
+
+func BenchmarkPlayoutBitboard(b *testing.B) {
+	_, board := newEngines(ctypes.DefaultOptions())
+	benchmarkPlayouts(b, board)
+}
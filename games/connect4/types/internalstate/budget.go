@@ -0,0 +1,49 @@
+package internalstate
+
+import (
+	"time"
+	ctypes "websockets/games/connect4/types"
+)
+
+// Up to MaxLatency of the clock is kept back from every budget so a move sent right at the end of it still
+// reaches the server in time.
+const (
+	MaxLatency = 500 * time.Millisecond
+	MinBudget  = 10 * time.Millisecond
+)
+
+// MoveBudget is how long agentId should think about its next move: fallback in untimed games, otherwise an even
+// share of its clock over the moves it could still have to make, never more than fallback.
+func MoveBudget(s *ctypes.UpdateGameState, agentId string, fallback time.Duration) time.Duration {
+	left, ok := s.TimeLeft(s.Players[agentId])
+	if !ok {
+		return fallback
+	}
+	reserve := left / 5
+	if reserve > MaxLatency {
+		reserve = MaxLatency
+	}
+	movesLeft := 1
+	if s.TimeControl.Kind != ctypes.ClockPerMove {
+		pieces := 0
+		for _, col := range s.Columns {
+			pieces += len(col)
+		}
+		movesLeft = (s.Width*s.Height-pieces+1)/2 + 1
+	}
+	toret := (left - reserve) / time.Duration(movesLeft)
+	if s.TimeControl.Kind == ctypes.ClockFischer {
+		// The increment comes back after every move, so most of it can be spent each time.
+		toret += time.Duration(s.TimeControl.Increment) * time.Millisecond * 4 / 5
+		if toret > left-reserve {
+			toret = left - reserve
+		}
+	}
+	if toret > fallback {
+		toret = fallback
+	}
+	if toret < MinBudget {
+		toret = MinBudget
+	}
+	return toret
+}
@@ -0,0 +1,102 @@
+package internalstate
+
+import (
+	"fmt"
+	"time"
+)
+
+// SearchFunc is one half of an agent's alpha-beta search: max for the agent's turns or min for its
+// opponent's. Given the move that led to is, it returns the best reply and its score for the agent, and
+// gives up once the search's Deadline says time is up.
+type SearchFunc func(is Engine, alpha, beta, move, depth int) (int, int)
+
+// Deadline cuts a search off once its time is up, reading the clock every Every nodes, or at every node if
+// Every is zero. Outside Deepen it never does, so searches run to their depth.
+type Deadline struct {
+	Every   int
+	at      time.Time
+	nodes   int
+	stopped bool
+}
+
+// TimeUp counts a node and reports whether the search has run past the deadline.
+func (deadline *Deadline) TimeUp() bool {
+	deadline.nodes += 1
+	if !deadline.stopped && !deadline.at.IsZero() && (deadline.Every == 0 || deadline.nodes%deadline.Every == 0) && time.Now().After(deadline.at) {
+		deadline.stopped = true
+	}
+	return deadline.stopped
+}
+
+// Deepen searches one move deeper at a time, up to maxDepth, until budget is spent, and returns the best
+// move and score from the deepest search that finished. Each search tries the last one's best move first,
+// which lets alpha-beta cut more of the rest.
+func Deepen(is Engine, deadline *Deadline, budget time.Duration, maxDepth int, max, min SearchFunc) (int, int) {
+	start := time.Now()
+	deadline.at = start.Add(budget)
+	deadline.nodes = 0
+	deadline.stopped = false
+	defer func() {
+		deadline.at = time.Time{}
+		deadline.stopped = false
+	}()
+
+	bestAction, bestScore, depth := is.GenerateMoves()[0], 0, 0
+	for depth < maxDepth {
+		action, score := searchRoot(is, depth+1, bestAction, max, min)
+		if deadline.stopped {
+			break
+		}
+		depth += 1
+		bestAction, bestScore = action, score
+		fmt.Printf("Depth %d: action %d, score %d, %d nodes so far\n", depth, action, score, deadline.nodes)
+		if score >= 1000 || score <= -1000 {
+			// The game is decided either way; deeper searches won't change that.
+			break
+		}
+	}
+	fmt.Printf("Searched to depth %d, %d nodes in %v\n", depth, deadline.nodes, time.Since(start))
+	return bestAction, bestScore
+}
+
+// searchRoot is max at the root when it's the agent's move and min when it's the opponent's, with first
+// tried before the other moves.
+func searchRoot(is Engine, depth, first int, max, min SearchFunc) (int, int) {
+	actions := is.GenerateMoves()
+	for i, action := range actions {
+		if action == first {
+			actions[0], actions[i] = actions[i], actions[0]
+		}
+	}
+	maximizing := is.ToMove() == is.AgentColor()
+	alpha, beta := -10000000, 10000000
+	bestAction := actions[0]
+	bestScore := -100000
+	if !maximizing {
+		bestScore = 100000
+	}
+	for _, action := range actions {
+		is.MakeMove(action)
+		var score int
+		if maximizing {
+			_, score = min(is, alpha, beta, action, depth-1)
+		} else {
+			_, score = max(is, alpha, beta, action, depth-1)
+		}
+		is.UnmakeMove()
+		if maximizing && score > bestScore {
+			bestAction = action
+			bestScore = score
+			if bestScore > alpha {
+				alpha = bestScore
+			}
+		} else if !maximizing && score < bestScore {
+			bestAction = action
+			bestScore = score
+			if bestScore < beta {
+				beta = bestScore
+			}
+		}
+	}
+	return bestAction, bestScore
+}
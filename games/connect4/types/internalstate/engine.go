@@ -0,0 +1,58 @@
+package internalstate
+
+import (
+	ctypes "websockets/games/connect4/types"
+)
+
+// Engine is a position the agents search: moves are made and unmade in place, and colors are 0 for Red
+// and 1 for Black, with -1 for none.
+type Engine interface {
+	GenerateMoves() []int
+	MakeMove(move int)
+	UnmakeMove()
+	// VictoryCheck returns the color with a line on the board, or -1.
+	VictoryCheck() int
+	// StalemateCheck reports a draw: the side to move has nothing to play.
+	StalemateCheck() bool
+	PopMove(col int) int
+	IsPop(move int) bool
+	MoveColumn(move int) int
+	// Key is the same for any two positions with the same pieces and side to move.
+	Key() uint64
+	ToMove() int
+	// AgentColor is the color of the agent the engine was made for.
+	AgentColor() int
+	// Ply is how many moves have been made since the engine was made.
+	Ply() int
+	// Evaluate scores the position for the agent by the location scores of its pieces, less its opponent's.
+	Evaluate() int
+	// Clone copies the position, so it can be searched separately, e.g. from another goroutine.
+	Clone() Engine
+}
+
+// NewEngine returns a Bitboard for s when the board fits in one, and an InternalState otherwise.
+func NewEngine(agentId string, s *ctypes.UpdateGameState) Engine {
+	if Fits(s.Width, s.Height) {
+		return NewBitboard(agentId, s)
+	}
+	return NewInternalState(agentId, s)
+}
+
+// Perft counts the move sequences depth moves long from engine's position, leaving out any that carry on
+// after the game has ended. Comparing counts between engines, or with published ones, checks move
+// generation and game results together.
+func Perft(engine Engine, depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	if engine.VictoryCheck() >= 0 || engine.StalemateCheck() {
+		return 0
+	}
+	toret := 0
+	for _, move := range engine.GenerateMoves() {
+		engine.MakeMove(move)
+		toret += Perft(engine, depth-1)
+		engine.UnmakeMove()
+	}
+	return toret
+}
@@ -0,0 +1,72 @@
+package internalstate
+
+import (
+	"testing"
+	ctypes "websockets/games/connect4/types"
+)
+
+// standardPerft is the number of Connect 4 games on the standard board at each ply, that is of move
+// sequences rather than of distinct positions, OEIS A090224. Every sequence is legal until seven of them
+// overfill a column at ply 7, and wins first cut the count at ply 8.
+var standardPerft = []int{1, 7, 49, 343, 2401, 16807, 117649, 823536, 5673234}
+
+func TestPerft(t *testing.T) {
+	is, board := newEngines(ctypes.DefaultOptions())
+	for _, engine := range []struct {
+		name     string
+		engine   Engine
+		maxDepth int
+	}{
+		{"InternalState", is, 7},
+		{"Bitboard", board, 8},
+	} {
+		for depth := 0; depth <= engine.maxDepth; depth += 1 {
+			if nodes := Perft(engine.engine, depth); nodes != standardPerft[depth] {
+				t.Errorf("%s: perft(%d) = %d, expected %d", engine.name, depth, nodes, standardPerft[depth])
+			}
+		}
+	}
+}
+
+// There are no published counts for the other variants, so the engines only have to agree on them.
+func TestPerftEnginesAgree(t *testing.T) {
+	for _, options := range []ctypes.Options{
+		{Width: 7, Height: 6, Connect: 4, PopOut: true},
+		{Width: 4, Height: 4, Connect: 3},
+		{Width: 4, Height: 4, Connect: 3, PopOut: true},
+		{Width: 5, Height: 9, Connect: 5},
+		{Width: 8, Height: 5, Connect: 2, PopOut: true},
+	} {
+		is, board := newEngines(options)
+		for depth := 0; depth <= 6; depth += 1 {
+			if expected, nodes := Perft(is, depth), Perft(board, depth); nodes != expected {
+				t.Errorf("%s popout %v: perft(%d) = %d from the Bitboard, %d from InternalState", options.Variant(), options.PopOut, depth, nodes, expected)
+			}
+		}
+	}
+}
+
+func benchmarkPerft(b *testing.B, engine Engine) {
+	nodes := 0
+	for i := 0; i < b.N; i += 1 {
+		nodes += Perft(engine, 6)
+	}
+	b.ReportMetric(float64(nodes)/b.Elapsed().Seconds(), "nodes/s")
+}
+
+func BenchmarkPerftInternalState(b *testing.B) {
+	is, _ := newEngines(ctypes.DefaultOptions())
+	benchmarkPerft(b, is)
+}
+
+func BenchmarkPerftBitboard(b *testing.B) {
+	_, board := newEngines(ctypes.DefaultOptions())
+	benchmarkPerft(b, board)
+}
+
+func BenchmarkPerftPopOutBitboard(b *testing.B) {
+	options := ctypes.DefaultOptions()
+	options.PopOut = true
+	_, board := newEngines(options)
+	benchmarkPerft(b, board)
+}
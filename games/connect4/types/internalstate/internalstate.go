@@ -1,9 +1,12 @@
 package internalstate
 
 import (
+	"hash/fnv"
 	ctypes "websockets/games/connect4/types"
 )
 
+// InternalState is a position on any size of board. NewEngine prefers the faster Bitboard when the board
+// fits in one.
 type InternalState struct {
 	LocScore [][]int
 	Board    [][]int
@@ -11,30 +14,53 @@ type InternalState struct {
 	Turn     int
 	Agent    int
 	Moves    []int
+	Width    int
+	Rows     int
+	Connect  int
+	PopOut   bool
 }
 
-func NewInternalState(agentId string, s *ctypes.UpdateGameState) *InternalState {
-	loc_score := [][]int{
-		[]int{3, 4, 5, 5, 4, 3},
-		[]int{4, 6, 8, 8, 6, 4},
-		[]int{5, 8, 11, 11, 8, 5},
-		[]int{7, 10, 13, 13, 10, 7},
-		[]int{5, 8, 11, 11, 8, 5},
-		[]int{4, 6, 8, 8, 6, 4},
-		[]int{3, 4, 5, 5, 4, 3},
+// locationScores counts, for every cell, how many winning lines of length n pass through it.
+// On the standard board this is the familiar 3, 4, 5, 7, 5, 4, 3 centre-weighted table.
+func locationScores(width, height, n int) [][]int {
+	toret := [][]int{}
+	for col := 0; col < width; col += 1 {
+		toret = append(toret, make([]int, height))
 	}
+	for _, delta := range [][2]int{{1, 0}, {0, 1}, {1, 1}, {-1, 1}} {
+		for col := 0; col < width; col += 1 {
+			for row := 0; row < height; row += 1 {
+				clast := col + (n-1)*delta[0]
+				rlast := row + (n-1)*delta[1]
+				if clast < 0 || clast >= width || rlast >= height {
+					continue
+				}
+				for i := 0; i < n; i += 1 {
+					toret[col+i*delta[0]][row+i*delta[1]] += 1
+				}
+			}
+		}
+	}
+	return toret
+}
+
+func NewInternalState(agentId string, s *ctypes.UpdateGameState) *InternalState {
 	toret := &InternalState{
-		LocScore: loc_score,
+		LocScore: locationScores(s.Width, s.Height, s.Connect),
 		Board:    [][]int{},
 		Height:   []int{},
 		Turn:     int(s.CurrentTurn),
 		Agent:    int(s.Players[agentId]),
 		Moves:    []int{},
+		Width:    s.Width,
+		Rows:     s.Height,
+		Connect:  s.Connect,
+		PopOut:   s.PopOut,
 	}
-	for col := 0; col < ctypes.Width; col += 1 {
+	for col := 0; col < s.Width; col += 1 {
 		toret.Board = append(toret.Board, []int{})
 		toret.Height = append(toret.Height, len(s.Columns[col]))
-		for row := 0; row < ctypes.Height; row += 1 {
+		for row := 0; row < s.Height; row += 1 {
 			if row < len(s.Columns[col]) {
 				toret.Board[col] = append(toret.Board[col], int(s.Columns[col][row]))
 			} else {
@@ -46,47 +72,134 @@ func NewInternalState(agentId string, s *ctypes.UpdateGameState) *InternalState
 }
 
 func (is *InternalState) ToString() string {
-	var toret [ctypes.Width * ctypes.Height]byte
+	toret := make([]byte, is.Width*is.Rows)
 	for col_i, col := range is.Board {
 		for row_i, piece := range col {
-			index := col_i*ctypes.Height + row_i
+			index := col_i*is.Rows + row_i
 			piece_p := uint8(piece + 1)
 			toret[index] = piece_p + 48
 		}
 	}
-	return string(toret[:])
+	return string(toret)
+}
+
+// Key hashes ToString and the side to move.
+func (is *InternalState) Key() uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(is.ToString()))
+	return hash.Sum64() ^ uint64(is.Turn)
+}
+
+func (is *InternalState) ToMove() int {
+	return is.Turn
+}
+
+func (is *InternalState) AgentColor() int {
+	return is.Agent
+}
+
+func (is *InternalState) Ply() int {
+	return len(is.Moves)
+}
+
+// Clone shares LocScore, which never changes, and copies everything else.
+func (is *InternalState) Clone() Engine {
+	toret := *is
+	toret.Board = [][]int{}
+	for _, col := range is.Board {
+		toret.Board = append(toret.Board, append([]int{}, col...))
+	}
+	toret.Height = append([]int{}, is.Height...)
+	toret.Moves = append([]int{}, is.Moves...)
+	return &toret
+}
+
+func (is *InternalState) Evaluate() int {
+	total := 0
+	for col, h := range is.Height {
+		for row := 0; row < h; row += 1 {
+			piece := is.Board[col][row]
+			if piece == is.Agent {
+				total += is.LocScore[col][row]
+			} else if piece != -1 {
+				total -= is.LocScore[col][row]
+			}
+		}
+	}
+	return total
+}
+
+// Moves are column numbers for drops. Under Pop Out, popping column col is the move Width + col.
+func (is *InternalState) PopMove(col int) int {
+	return is.Width + col
+}
+
+func (is *InternalState) IsPop(move int) bool {
+	return move >= is.Width
+}
+
+func (is *InternalState) MoveColumn(move int) int {
+	if is.IsPop(move) {
+		return move - is.Width
+	}
+	return move
 }
 
 func (is *InternalState) GenerateMoves() []int {
 	toret := []int{}
 	for i, h := range is.Height {
-		if h < ctypes.Height {
+		if h < is.Rows {
 			toret = append(toret, i)
 		}
 	}
+	if is.PopOut {
+		for i, h := range is.Height {
+			if h > 0 && is.Board[i][0] == is.Turn {
+				toret = append(toret, is.PopMove(i))
+			}
+		}
+	}
 	return toret
 }
 
-func (is *InternalState) MakeMove(col int) {
+func (is *InternalState) MakeMove(move int) {
+	col := is.MoveColumn(move)
 	Height := is.Height[col]
-	is.Board[col][Height] = is.Turn
-	is.Height[col] = Height + 1
+	if is.IsPop(move) {
+		copy(is.Board[col], is.Board[col][1:Height])
+		is.Board[col][Height-1] = -1
+		is.Height[col] = Height - 1
+	} else {
+		is.Board[col][Height] = is.Turn
+		is.Height[col] = Height + 1
+	}
 	is.Turn = 1 - is.Turn
-	is.Moves = append(is.Moves, col)
+	is.Moves = append(is.Moves, move)
 }
 
 func (is *InternalState) UnmakeMove() {
-	col := is.Moves[len(is.Moves)-1]
+	move := is.Moves[len(is.Moves)-1]
+	col := is.MoveColumn(move)
 	Height := is.Height[col]
-	is.Board[col][Height-1] = -1
-	is.Height[col] = Height - 1
 	is.Turn = 1 - is.Turn
+	if is.IsPop(move) {
+		copy(is.Board[col][1:Height+1], is.Board[col][:Height])
+		is.Board[col][0] = is.Turn
+		is.Height[col] = Height + 1
+	} else {
+		is.Board[col][Height-1] = -1
+		is.Height[col] = Height - 1
+	}
 	is.Moves = is.Moves[:len(is.Moves)-1]
 }
 
+// StalemateCheck reports a draw: the side to move has nothing to play.
 func (is *InternalState) StalemateCheck() bool {
-	for _, h := range is.Height {
-		if h != ctypes.Height {
+	for i, h := range is.Height {
+		if h != is.Rows {
+			return false
+		}
+		if is.PopOut && is.Board[i][0] == is.Turn {
 			return false
 		}
 	}
@@ -96,13 +209,14 @@ func (is *InternalState) StalemateCheck() bool {
 func (is *InternalState) directionalWinCheckLastMove(cdelta, rdelta int) int {
 	col := is.Moves[len(is.Moves)-1]
 	row := is.Height[col] - 1
+	span := is.Connect - 1
 
-	rlast := row + (3 * rdelta)
-	clast := col + (3 * cdelta)
+	rlast := row + (span * rdelta)
+	clast := col + (span * cdelta)
 	if rlast < 0 || clast < 0 {
 		return -1
 	}
-	if rlast >= ctypes.Height || clast >= ctypes.Width {
+	if rlast >= is.Rows || clast >= is.Width {
 		return -1
 	}
 
@@ -110,7 +224,7 @@ func (is *InternalState) directionalWinCheckLastMove(cdelta, rdelta int) int {
 	if checkingPiece == -1 {
 		return -1
 	}
-	for i := 1; i < 4; i += 1 {
+	for i := 1; i < is.Connect; i += 1 {
 		rind := row + (i * rdelta)
 		cind := col + (i * cdelta)
 		if is.Board[cind][rind] != checkingPiece {
@@ -121,22 +235,28 @@ func (is *InternalState) directionalWinCheckLastMove(cdelta, rdelta int) int {
 }
 
 func (is *InternalState) directionalWinCheck(cdelta, rdelta int) int {
+	return is.directionalWinCheckFor(-1, cdelta, rdelta)
+}
+
+// directionalWinCheckFor looks for a line of piece, or of either color when piece is -1.
+func (is *InternalState) directionalWinCheckFor(piece, cdelta, rdelta int) int {
+	span := is.Connect - 1
 	for col, h := range is.Height {
 		for row := 0; row < h; row += 1 {
-			rlast := row + (3 * rdelta)
-			clast := col + (3 * cdelta)
+			rlast := row + (span * rdelta)
+			clast := col + (span * cdelta)
 			if rlast < 0 || clast < 0 {
 				continue
 			}
-			if rlast >= ctypes.Height || clast >= ctypes.Width {
+			if rlast >= is.Rows || clast >= is.Width {
 				continue
 			}
 			match := true
 			checkingPiece := is.Board[col][row]
-			if checkingPiece == -1 {
+			if checkingPiece == -1 || (piece != -1 && checkingPiece != piece) {
 				continue
 			}
-			for i := 1; i < 4; i += 1 {
+			for i := 1; i < is.Connect; i += 1 {
 				rind := row + (i * rdelta)
 				cind := col + (i * cdelta)
 				if is.Board[cind][rind] != checkingPiece {
@@ -152,7 +272,25 @@ func (is *InternalState) directionalWinCheck(cdelta, rdelta int) int {
 	return -1
 }
 
+func (is *InternalState) winFor(piece int) bool {
+	return is.directionalWinCheckFor(piece, 1, 0) > -1 ||
+		is.directionalWinCheckFor(piece, 0, 1) > -1 ||
+		is.directionalWinCheckFor(piece, 1, 1) > -1 ||
+		is.directionalWinCheckFor(piece, -1, 1) > -1
+}
+
 func (is *InternalState) VictoryCheck() int {
+	if len(is.Moves) > 0 && is.IsPop(is.Moves[len(is.Moves)-1]) {
+		// A pop can connect both colors at once, in which case the player who popped wins.
+		popper := 1 - is.Turn
+		if is.winFor(popper) {
+			return popper
+		}
+		if is.winFor(is.Turn) {
+			return is.Turn
+		}
+		return -1
+	}
 	v := is.directionalWinCheck(1, 0)
 	if v > -1 {
 		return v
@@ -0,0 +1,67 @@
+package internalstate
+
+import (
+	"testing"
+	"time"
+	ctypes "websockets/games/connect4/types"
+)
+
+func newState(options ctypes.Options) *InternalState {
+	s := &ctypes.UpdateGameState{
+		GameState: ctypes.NewGameState(options),
+		Players:   map[string]ctypes.Color{"agent": ctypes.Red},
+	}
+	return NewInternalState("agent", s)
+}
+
+func TestPopMakeUnmake(t *testing.T) {
+	options := ctypes.DefaultOptions()
+	options.PopOut = true
+	is := newState(options)
+	for _, col := range []int{2, 2, 2, 2} {
+		is.MakeMove(col)
+	}
+	before := is.ToString()
+	pop := is.PopMove(2)
+	found := false
+	for _, move := range is.GenerateMoves() {
+		found = found || move == pop
+	}
+	if !found {
+		t.Fatalf("expected red to be able to pop column 2, moves %v", is.GenerateMoves())
+	}
+	is.MakeMove(is.PopMove(2))
+	if is.Height[2] != 3 || is.Board[2][0] != 1 || is.Board[2][1] != 0 || is.Board[2][2] != 1 || is.Board[2][3] != -1 {
+		t.Errorf("unexpected column after pop: %v", is.Board[2])
+	}
+	is.UnmakeMove()
+	if is.ToString() != before || is.Turn != 0 {
+		t.Errorf("expected unmaking a pop to restore %s, got %s", before, is.ToString())
+	}
+}
+
+func TestMoveBudget(t *testing.T) {
+	s := &ctypes.UpdateGameState{
+		GameState: ctypes.NewGameState(ctypes.DefaultOptions()),
+		Players:   map[string]ctypes.Color{"agent": ctypes.Black},
+	}
+	if budget := MoveBudget(s, "agent", time.Second); budget != time.Second {
+		t.Errorf("untimed games should use the fallback, got %v", budget)
+	}
+	s.TimeControl = ctypes.TimeControl{Kind: ctypes.ClockSuddenDeath, Initial: 60000}
+	s.Remaining = []int64{60000, 22500}
+	// 42 empty squares leave black at most 22 more moves; 500ms is kept back for latency.
+	if budget := MoveBudget(s, "agent", time.Minute); budget != 22*time.Second/22 {
+		t.Errorf("sudden death: got %v", budget)
+	}
+	s.TimeControl = ctypes.TimeControl{Kind: ctypes.ClockPerMove, PerMove: 2000}
+	s.Remaining = []int64{2000, 2000}
+	if budget := MoveBudget(s, "agent", time.Minute); budget != 1600*time.Millisecond {
+		t.Errorf("per move: got %v", budget)
+	}
+	s.TimeControl = ctypes.TimeControl{Kind: ctypes.ClockFischer, Initial: 1000, Increment: 5000}
+	s.Remaining = []int64{1000, 100}
+	if budget := MoveBudget(s, "agent", time.Minute); budget != 80*time.Millisecond {
+		t.Errorf("fischer budgets can't exceed the clock, got %v", budget)
+	}
+}
@@ -0,0 +1,60 @@
+package connect4
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MoveRecord is one entry in a game's history.
+type MoveRecord struct {
+	PlayerId string
+	Color    Color
+	Col      int
+	Pop      bool
+	Time     time.Time
+}
+
+// Notation writes moves in the usual column digit notation, numbering columns from 1 on the left, so "4453"
+// is two drops in the middle column of a standard board followed by one either side. Pop Out pops are
+// written with a "p" before the column. Boards wider than nine columns have no digit notation.
+func Notation(history []MoveRecord) (string, error) {
+	var toret strings.Builder
+	for _, move := range history {
+		if move.Col < 0 || move.Col > 8 {
+			return "", fmt.Errorf("Column %d has no single digit notation", move.Col+1)
+		}
+		if move.Pop {
+			toret.WriteByte('p')
+		}
+		toret.WriteByte(byte('1' + move.Col))
+	}
+	return toret.String(), nil
+}
+
+// ParseNotation reads column digit notation back into moves. It doesn't check them against any rules.
+func ParseNotation(notation string) ([]MoveData, error) {
+	toret := []MoveData{}
+	pop := false
+	for i, c := range notation {
+		switch {
+		case c == 'p':
+			if pop {
+				return nil, fmt.Errorf("Unexpected 'p' at position %d", i)
+			}
+			pop = true
+		case c >= '1' && c <= '9':
+			toret = append(toret, MoveData{
+				Col: int(c - '1'),
+				Pop: pop,
+			})
+			pop = false
+		default:
+			return nil, fmt.Errorf("Unexpected %q at position %d", c, i)
+		}
+	}
+	if pop {
+		return nil, fmt.Errorf("Notation ends with a 'p'")
+	}
+	return toret, nil
+}
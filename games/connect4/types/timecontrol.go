@@ -0,0 +1,74 @@
+package connect4
+
+import (
+	"fmt"
+	"time"
+)
+
+// ClockKind picks how a game's clocks run. The empty kind means the game is untimed.
+type ClockKind string
+
+const (
+	ClockNone ClockKind = ""
+	// ClockSuddenDeath gives each player Initial for the whole game.
+	ClockSuddenDeath ClockKind = "SuddenDeath"
+	// ClockFischer gives each player Initial, adding Increment after each of their moves.
+	ClockFischer ClockKind = "Fischer"
+	// ClockPerMove gives each player PerMove for every move, with nothing carried over.
+	ClockPerMove ClockKind = "PerMove"
+)
+
+// TimeControl is the clock a room is created with. Times are in milliseconds.
+type TimeControl struct {
+	Kind      ClockKind
+	Initial   int64
+	Increment int64
+	PerMove   int64
+}
+
+func (control TimeControl) Validate() error {
+	switch control.Kind {
+	case ClockNone:
+		return nil
+	case ClockSuddenDeath, ClockFischer:
+		if control.Initial <= 0 {
+			return fmt.Errorf("%s clocks need a positive Initial time", control.Kind)
+		}
+		if control.Increment < 0 {
+			return fmt.Errorf("Increment can't be negative")
+		}
+	case ClockPerMove:
+		if control.PerMove <= 0 {
+			return fmt.Errorf("PerMove clocks need a positive PerMove time")
+		}
+	default:
+		return fmt.Errorf("Unknown clock kind %q", control.Kind)
+	}
+	return nil
+}
+
+// String names the time control, e.g. "Fischer 60000+2000", or "Untimed".
+func (control TimeControl) String() string {
+	switch control.Kind {
+	case ClockNone:
+		return "Untimed"
+	case ClockSuddenDeath:
+		return fmt.Sprintf("%s %d", control.Kind, control.Initial)
+	case ClockFischer:
+		return fmt.Sprintf("%s %d+%d", control.Kind, control.Initial, control.Increment)
+	default:
+		return fmt.Sprintf("%s %d", control.Kind, control.PerMove)
+	}
+}
+
+func Millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// TimeLeft returns how long color had on its clock when update was sent, and false if the game is untimed.
+func (update *UpdateGameState) TimeLeft(color Color) (time.Duration, bool) {
+	if int(color) < 0 || int(color) >= len(update.Remaining) {
+		return 0, false
+	}
+	return time.Duration(update.Remaining[color]) * time.Millisecond, true
+}
@@ -1,39 +1,248 @@
 package connect4
 
-import ()
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 const (
 	Red = iota
 	Black
 )
 
+// The standard board, used for any option a room doesn't set.
+const (
+	DefaultWidth   int = 7
+	DefaultHeight  int = 6
+	DefaultConnect int = 4
+)
+
 const (
-	Width  int = 7
-	Height int = 6
+	MinSize int = 4
+	MaxSize int = 16
 )
 
+// MaxBestOf is the longest series a room can be set up to play.
+const MaxBestOf int = 25
+
 type Color int
 
+// NoColor is the WinnerColor of a drawn game.
+const NoColor Color = -1
+
+// Reason explains how a game ended.
+type Reason string
+
+const (
+	ReasonConnected   Reason = "Connected"
+	ReasonBoardFull   Reason = "BoardFull"
+	ReasonResignation Reason = "Resignation"
+	ReasonTimeout     Reason = "Timeout"
+	// ReasonAgreement is a draw both players agreed to.
+	ReasonAgreement Reason = "Agreement"
+	// ReasonAborted ends a game before its first move, with no winner and no draw. Aborted games aren't rated.
+	ReasonAborted Reason = "Aborted"
+)
+
+// Result is set on a GameState once the game is over.
+type Result struct {
+	WinnerId    string
+	WinnerColor Color
+	Draw        bool
+	Reason      Reason
+}
+
 type Position struct {
 	Row int
 	Col int
 }
 
+// MoveData is a player's message to the game. Pop removes the player's own piece from the bottom of Col
+// rather than dropping one in, and is only allowed when the PopOut option is set.
+//
+// The other fields are commands rather than moves, and can be sent whoever's turn it is. OfferDraw stands
+// until the opponent answers with AcceptDraw or DeclineDraw, or makes a move; offering back accepts. Abort
+// ends a game nobody has moved in yet.
 type MoveData struct {
-	Col     int
-	Rematch bool
+	Col         int
+	Pop         bool
+	Rematch     bool
+	Resign      bool
+	OfferDraw   bool
+	AcceptDraw  bool
+	DeclineDraw bool
+	Abort       bool
+}
+
+// Options are the room creation options for a Connect4 game: the board size, how many in a row win,
+// whether the Pop Out rules are in play, and the clock.
+//
+// Players swap colors with every rematch, so the first move alternates. RandomColors seats the first two
+// players at random rather than giving Red to whoever joins first. BestOf, if above 1, plays the room as a
+// series of that many games.
+type Options struct {
+	Width        int
+	Height       int
+	Connect      int
+	PopOut       bool
+	TimeControl  TimeControl
+	RandomColors bool
+	BestOf       int
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Width:   DefaultWidth,
+		Height:  DefaultHeight,
+		Connect: DefaultConnect,
+	}
+}
+
+// ParseOptions reads JSON room options, keeping the defaults for anything left unset.
+func ParseOptions(optionsJson []byte) (Options, error) {
+	options := DefaultOptions()
+	if len(optionsJson) > 0 {
+		err := json.Unmarshal(optionsJson, &options)
+		if err != nil {
+			return options, err
+		}
+	}
+	return options, options.Validate()
+}
+
+// Variant names the rules options describe, e.g. "7x6-4" or "7x6-4-popout". Games are only rated against
+// others of the same variant.
+func (options Options) Variant() string {
+	toret := fmt.Sprintf("%dx%d-%d", options.Width, options.Height, options.Connect)
+	if options.PopOut {
+		toret += "-popout"
+	}
+	return toret
+}
+
+func (options Options) Validate() error {
+	if options.Width < MinSize || options.Width > MaxSize {
+		return fmt.Errorf("Width must be between %d and %d", MinSize, MaxSize)
+	}
+	if options.Height < MinSize || options.Height > MaxSize {
+		return fmt.Errorf("Height must be between %d and %d", MinSize, MaxSize)
+	}
+	if options.Connect < 2 || (options.Connect > options.Width && options.Connect > options.Height) {
+		return fmt.Errorf("Connect must be at least 2 and fit on the board")
+	}
+	if options.BestOf < 0 || options.BestOf > MaxBestOf || (options.BestOf > 1 && options.BestOf%2 == 0) {
+		return fmt.Errorf("BestOf must be an odd number of games up to %d", MaxBestOf)
+	}
+	return options.TimeControl.Validate()
 }
 
+// GameState is the state of one game. DrawOffer is the id of the player with a draw offer standing, if any.
 type GameState struct {
+	Options
 	CurrentTurn      Color
-	Columns          [Width][]Color
+	Columns          [][]Color
 	GameOver         bool
+	Result           *Result
 	WinningPositions []Position
+	History          []MoveRecord
+	DrawOffer        string
 }
 
+// UpdateGameState is sent to every player and spectator after each change. Notation is the History in
+// column digit notation, or empty when the board is too wide to have one. Remaining is each color's time
+// left in milliseconds as of sending, indexed by Color, and is empty in untimed games. Series is nil
+// unless the room plays a series.
 type UpdateGameState struct {
 	GameState
-	Players map[string]Color
+	Notation   string
+	Players    map[string]Color
+	Spectators []string
+	Remaining  []int64
+	Series     *Series
+	// FirstPly is how many moves of History were imported with the starting position. The game can be
+	// aborted until a move is played after them.
+	FirstPly int
+}
+
+// DrawOfferedTo reports whether playerId has a draw offer from their opponent to answer.
+func (update *UpdateGameState) DrawOfferedTo(playerId string) bool {
+	return !update.GameOver && update.DrawOffer != "" && update.DrawOffer != playerId
+}
+
+// Series is the running score of a best-of-N series, kept across rematches. Wins are keyed by player id,
+// since colors swap from game to game. Game is the number of the game being played, counting from 1;
+// aborted games don't count. Once the series is Over, WinnerId is whoever won more games, or empty if
+// it was tied.
+type Series struct {
+	BestOf   int
+	Game     int
+	Wins     map[string]int
+	Draws    int
+	Over     bool
+	WinnerId string
+}
+
+func NewSeries(bestOf int) *Series {
+	return &Series{
+		BestOf: bestOf,
+		Game:   1,
+		Wins:   map[string]int{},
+	}
+}
+
+// Played is how many games of the series have been finished.
+func (series *Series) Played() int {
+	toret := series.Draws
+	for _, wins := range series.Wins {
+		toret += wins
+	}
+	return toret
+}
+
+// Record adds a finished game to the score, ending the series once it's decided.
+func (series *Series) Record(result *Result) {
+	if series.Over || result.Reason == ReasonAborted {
+		return
+	}
+	if result.Draw || result.WinnerId == "" {
+		series.Draws += 1
+	} else {
+		series.Wins[result.WinnerId] += 1
+	}
+	leader, lead := "", 0
+	for id, wins := range series.Wins {
+		margin := wins
+		for other, otherWins := range series.Wins {
+			if other != id {
+				margin -= otherWins
+			}
+		}
+		if margin > lead {
+			leader, lead = id, margin
+		}
+	}
+	remaining := series.BestOf - series.Played()
+	if lead > remaining || remaining <= 0 {
+		series.Over = true
+		series.WinnerId = leader
+	}
+}
+
+// GameRecord summarises one finished game, for anything that wants to keep it after the room is gone.
+type GameRecord struct {
+	Options
+	Players   map[string]Color
+	History   []MoveRecord
+	Result    Result
+	StartedAt time.Time
+	EndedAt   time.Time
+	// FirstPly is how many moves of History were imported with the starting position rather than played.
+	FirstPly int
+	// SeriesGame is which game of a best-of-BestOf series this was, counting from 1, or 0 outside a series.
+	SeriesGame int
+	// Updates are the UpdateGameState messages clients were sent, in order, ending with the game over.
+	Updates [][]byte `json:"-"`
 }
 
 type PlayerInfo struct {
@@ -42,8 +251,14 @@ type PlayerInfo struct {
 	RematchAttempt bool
 }
 
-func NewGameState() GameState {
-	return GameState{
-		Columns: [Width][]Color{{}, {}, {}, {}, {}, {}, {}},
+func NewGameState(options Options) GameState {
+	toret := GameState{
+		Options: options,
+		Columns: [][]Color{},
+		History: []MoveRecord{},
+	}
+	for col := 0; col < options.Width; col += 1 {
+		toret.Columns = append(toret.Columns, []Color{})
 	}
+	return toret
 }
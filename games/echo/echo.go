@@ -1,34 +1,93 @@
 package echo
 
 import (
+	"encoding/json"
 	"fmt"
+	"sync"
+	"websockets/games"
+	"websockets/games/types"
 )
 
+// Message is what every joined player receives when anyone in the game sends a move.
+type Message struct {
+	PlayerId string
+	Data     string
+}
+
 type EchoGame struct {
-	msgs chan []byte
+	mutex       sync.Mutex
+	players     map[string]chan []byte
+	moveChannel chan *types.Move
+}
+
+func init() {
+	games.Register("echo", func(options []byte) (types.Game, error) {
+		return NewEchoGame(), nil
+	})
 }
 
 func NewEchoGame() *EchoGame {
-	msgs := make(chan []byte, 16)
-	return &EchoGame{
-		msgs: msgs,
+	toret := &EchoGame{
+		players:     map[string]chan []byte{},
+		moveChannel: make(chan *types.Move, 16),
+	}
+	go toret.gameLoop()
+	return toret
+}
+
+func (game *EchoGame) gameLoop() {
+	for move := range game.moveChannel {
+		msg, _ := json.Marshal(&Message{
+			PlayerId: move.PlayerId,
+			Data:     string(move.Data),
+		})
+		game.broadcast(msg)
+	}
+}
+
+func (game *EchoGame) broadcast(msg []byte) {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	for playerId, updates := range game.players {
+		select {
+		case updates <- msg:
+		default:
+			fmt.Println("PLAYER " + playerId + " IS NOT KEEPING UP, DROPPING MESSAGE")
+		}
 	}
 }
 
 func (game *EchoGame) Join(playerId string) error {
-	fmt.Println(playerId + " has joined the game.")
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if _, ok := game.players[playerId]; !ok {
+		fmt.Println(playerId + " has joined the game.")
+		game.players[playerId] = make(chan []byte, 16)
+	}
 	return nil
 }
 
 func (game *EchoGame) Leave(playerId string) error {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
 	fmt.Println(playerId + " has left the game.")
+	delete(game.players, playerId)
 	return nil
 }
 
-func (game *EchoGame) UpdatesChannel(playerId string) <-chan []byte {
-	return game.msgs
+func (game *EchoGame) UpdatesChannel(playerId string) (<-chan []byte, error) {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if updates, ok := game.players[playerId]; ok {
+		return updates, nil
+	}
+	return nil, fmt.Errorf("No player in game with id %s", playerId)
+}
+
+func (game *EchoGame) MovesChannel(playerId string) (chan<- *types.Move, error) {
+	return game.moveChannel, nil
 }
 
-func (game *EchoGame) MovesChannel(playerId string) chan<- []byte {
-	return game.msgs
+func (game *EchoGame) Close() {
+	close(game.moveChannel)
 }
@@ -0,0 +1,47 @@
+package games
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"websockets/games/types"
+)
+
+// Factory builds a new game from its JSON encoded options. Options may be empty.
+type Factory func(options []byte) (types.Game, error)
+
+var (
+	mutex     sync.Mutex
+	factories = map[string]Factory{}
+)
+
+// Register makes a game available by name. It is meant to be called from the init function of a game package.
+func Register(name string, factory Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("games: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+func New(name string, options []byte) (types.Game, error) {
+	mutex.Lock()
+	factory, ok := factories[name]
+	mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("No game registered with name %s", name)
+	}
+	return factory(options)
+}
+
+func Names() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	toret := []string{}
+	for name := range factories {
+		toret = append(toret, name)
+	}
+	sort.Strings(toret)
+	return toret
+}
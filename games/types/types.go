@@ -19,3 +19,15 @@ type Game interface {
 	MovesChannel(playerId string) (chan<- *Move, error)
 	Close()
 }
+
+// Finisher is implemented by games that can report when play has ended.
+type Finisher interface {
+	Finished() bool
+}
+
+// Spectatable is implemented by games that can stream their updates to watchers who don't play.
+// A spectator's updates come from UpdatesChannel like a player's, and are closed by StopSpectating.
+type Spectatable interface {
+	Spectate(spectatorId string) error
+	StopSpectating(spectatorId string) error
+}
@@ -6,4 +6,5 @@ require (
 	github.com/gorilla/websocket v1.4.1
 	github.com/mattn/go-sqlite3 v1.13.0
 	github.com/satori/go.uuid v1.2.0
+	golang.org/x/crypto v0.9.0
 )
@@ -0,0 +1,143 @@
+package lobby
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"websockets/gameroom"
+	"websockets/games"
+	"websockets/games/types"
+)
+
+type RoomInfo struct {
+	Id      string
+	Game    string
+	Players int
+}
+
+type room struct {
+	*gameroom.GameRoom
+	gameType string
+}
+
+// RoomHook is called with every room the lobby creates, before anyone can connect to it.
+type RoomHook func(roomId, gameType string, game types.Game)
+
+type Lobby struct {
+	mutex sync.Mutex
+	rooms map[string]*room
+	hooks []RoomHook
+	// emptyTimeout is how long a room can sit with nobody connected before it's reaped, whether or not its
+	// game has ended.
+	emptyTimeout time.Duration
+}
+
+func NewLobby(reapInterval, emptyTimeout time.Duration) *Lobby {
+	toret := &Lobby{
+		rooms:        map[string]*room{},
+		emptyTimeout: emptyTimeout,
+	}
+	go toret.reapLoop(reapInterval)
+	return toret
+}
+
+// CreateRoom builds a game registered under gameType with the given JSON options and opens a room for it.
+func (lobby *Lobby) CreateRoom(gameType string, options []byte) (*gameroom.GameRoom, error) {
+	return lobby.createRoom(gameType, options, nil)
+}
+
+// CreateReservedRoom is CreateRoom for a room whose seats only playerIds can take.
+func (lobby *Lobby) CreateReservedRoom(gameType string, options []byte, playerIds []string) (*gameroom.GameRoom, error) {
+	return lobby.createRoom(gameType, options, playerIds)
+}
+
+func (lobby *Lobby) createRoom(gameType string, options []byte, reserved []string) (*gameroom.GameRoom, error) {
+	game, err := games.New(gameType, options)
+	if err != nil {
+		return nil, err
+	}
+	gr, err := gameroom.NewGameRoom(game)
+	if err != nil {
+		game.Close()
+		return nil, err
+	}
+	if reserved != nil {
+		gr.Reserve(reserved...)
+	}
+	lobby.mutex.Lock()
+	hooks := lobby.hooks
+	lobby.mutex.Unlock()
+	for _, hook := range hooks {
+		hook(gr.Id, gameType, game)
+	}
+
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+	lobby.rooms[gr.Id] = &room{
+		GameRoom: gr,
+		gameType: gameType,
+	}
+	return gr, nil
+}
+
+func (lobby *Lobby) AddRoomHook(hook RoomHook) {
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+	lobby.hooks = append(lobby.hooks, hook)
+}
+
+func (lobby *Lobby) Room(roomId string) (*gameroom.GameRoom, error) {
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+	if r, ok := lobby.rooms[roomId]; ok {
+		return r.GameRoom, nil
+	}
+	return nil, fmt.Errorf("No room with id %s", roomId)
+}
+
+// snapshot copies the rooms so they can be asked about their games without holding the lobby's lock, which
+// would leave every room waiting on the slowest game.
+func (lobby *Lobby) snapshot() map[string]*room {
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+	toret := make(map[string]*room, len(lobby.rooms))
+	for id, r := range lobby.rooms {
+		toret[id] = r
+	}
+	return toret
+}
+
+// OpenRooms lists every room whose game is still in progress.
+func (lobby *Lobby) OpenRooms() []RoomInfo {
+	toret := []RoomInfo{}
+	for id, r := range lobby.snapshot() {
+		if r.Finished() {
+			continue
+		}
+		toret = append(toret, RoomInfo{
+			Id:      id,
+			Game:    r.gameType,
+			Players: r.Connections(),
+		})
+	}
+	return toret
+}
+
+func (lobby *Lobby) reapLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		lobby.reap()
+	}
+}
+
+// reap closes and forgets rooms that nobody is connected to, once their game has ended or they've been empty
+// for emptyTimeout. That covers rooms nobody joined, abandoned games and games that never end.
+func (lobby *Lobby) reap() {
+	for id, r := range lobby.snapshot() {
+		if r.CloseIfIdle(lobby.emptyTimeout) {
+			fmt.Println("REAPING ROOM " + id)
+			lobby.mutex.Lock()
+			delete(lobby.rooms, id)
+			lobby.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package lobby
+
+import (
+	"testing"
+	"time"
+	_ "websockets/games/echo"
+)
+
+// Echo games never finish, so their rooms only go once they've been empty for too long.
+func TestReapEmptyRooms(t *testing.T) {
+	lobby := NewLobby(time.Hour, time.Hour)
+	gr, err := lobby.CreateRoom("echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lobby.reap()
+	if _, err := lobby.Room(gr.Id); err != nil {
+		t.Fatal("a room shouldn't be reaped as soon as it's opened")
+	}
+	lobby.emptyTimeout = 0
+	lobby.reap()
+	if _, err := lobby.Room(gr.Id); err == nil {
+		t.Error("expected a room left empty past the timeout to be reaped")
+	}
+	if len(lobby.OpenRooms()) != 0 {
+		t.Error("a reaped room shouldn't be listed")
+	}
+}
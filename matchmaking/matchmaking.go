@@ -0,0 +1,162 @@
+package matchmaking
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Request is the first message a client sends to the matchmaker.
+type Request struct {
+	Game    string
+	Options json.RawMessage
+	// Opponent, if set, only matches against that player.
+	Opponent string
+	// RatingRange, if positive, only matches against players rated at most that far away.
+	RatingRange float64
+}
+
+const (
+	StatusQueued  = "Queued"
+	StatusMatched = "Matched"
+)
+
+// Status is what the matchmaker tells a queued client: first that it's queued, then who it was matched with
+// and the room they've both been given seats in.
+type Status struct {
+	Status   string
+	RoomId   string `json:",omitempty"`
+	Game     string `json:",omitempty"`
+	Opponent string `json:",omitempty"`
+}
+
+// Ticket is a player waiting in a queue. Queue is the key the game and options were resolved to; only
+// tickets with the same Queue can be matched.
+type Ticket struct {
+	PlayerId string
+	Queue    string
+	Rating   float64
+	Request
+	matched chan *Match
+}
+
+type Match struct {
+	RoomId   string
+	Opponent string
+	Err      error
+}
+
+// Matched delivers the ticket's match once it has one.
+func (ticket *Ticket) Matched() <-chan *Match {
+	return ticket.matched
+}
+
+func (ticket *Ticket) accepts(other *Ticket) bool {
+	if ticket.Opponent != "" && ticket.Opponent != other.PlayerId {
+		return false
+	}
+	if ticket.RatingRange > 0 && math.Abs(ticket.Rating-other.Rating) > ticket.RatingRange {
+		return false
+	}
+	return true
+}
+
+func compatible(a, b *Ticket) bool {
+	return a.Queue == b.Queue && a.PlayerId != b.PlayerId && a.accepts(b) && b.accepts(a)
+}
+
+// QueueFunc validates a request, returning the queue it joins and how playerId is rated there. It may rewrite
+// req.Options into a canonical form, since the room is made with the options of whoever queued first.
+type QueueFunc func(playerId string, req *Request) (string, float64, error)
+
+// RoomFunc opens a room for game with seats reserved for playerIds, returning its id.
+type RoomFunc func(game string, options []byte, playerIds []string) (string, error)
+
+type Matchmaker struct {
+	mutex      sync.Mutex
+	waiting    []*Ticket
+	queueFor   QueueFunc
+	createRoom RoomFunc
+}
+
+func NewMatchmaker(queueFor QueueFunc, createRoom RoomFunc) *Matchmaker {
+	return &Matchmaker{
+		queueFor:   queueFor,
+		createRoom: createRoom,
+	}
+}
+
+func NewTicket(playerId, queue string, rating float64, req *Request) *Ticket {
+	return &Ticket{
+		PlayerId: playerId,
+		Queue:    queue,
+		Rating:   rating,
+		Request:  *req,
+		matched:  make(chan *Match, 1),
+	}
+}
+
+// Enqueue pairs ticket with the longest waiting compatible ticket, or leaves it waiting for one. A player
+// only holds one ticket at a time; queueing again replaces the old one.
+func (mm *Matchmaker) Enqueue(ticket *Ticket) {
+	mm.mutex.Lock()
+	var opponent *Ticket
+	waiting := mm.waiting[:0]
+	for _, other := range mm.waiting {
+		if other.PlayerId == ticket.PlayerId {
+			other.matched <- &Match{Err: fmt.Errorf("Queued again elsewhere")}
+			continue
+		}
+		if opponent == nil && compatible(ticket, other) {
+			opponent = other
+			continue
+		}
+		waiting = append(waiting, other)
+	}
+	if opponent == nil {
+		waiting = append(waiting, ticket)
+	}
+	mm.waiting = waiting
+	mm.mutex.Unlock()
+
+	if opponent != nil {
+		// The room is made outside the lock so a slow game doesn't hold up the queue.
+		go mm.match(opponent, ticket)
+	}
+}
+
+func (mm *Matchmaker) match(first, second *Ticket) {
+	roomId, err := mm.createRoom(first.Game, first.Options, []string{first.PlayerId, second.PlayerId})
+	if err == nil {
+		fmt.Println("MATCHED " + first.PlayerId + " AND " + second.PlayerId + " IN ROOM " + roomId)
+	}
+	first.matched <- &Match{RoomId: roomId, Opponent: second.PlayerId, Err: err}
+	second.matched <- &Match{RoomId: roomId, Opponent: first.PlayerId, Err: err}
+}
+
+// Cancel takes ticket out of the queue, reporting whether it was still waiting.
+func (mm *Matchmaker) Cancel(ticket *Ticket) bool {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	for i, other := range mm.waiting {
+		if other == ticket {
+			mm.waiting = append(mm.waiting[:i], mm.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Waiting returns how many tickets are queued for queue.
+func (mm *Matchmaker) Waiting(queue string) int {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	toret := 0
+	for _, ticket := range mm.waiting {
+		if ticket.Queue == queue {
+			toret += 1
+		}
+	}
+	return toret
+}
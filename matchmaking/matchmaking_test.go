@@ -0,0 +1,100 @@
+package matchmaking
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type roomLog struct {
+	rooms chan []string
+}
+
+func (log *roomLog) create(game string, options []byte, playerIds []string) (string, error) {
+	log.rooms <- playerIds
+	return fmt.Sprintf("room-%s-%s", playerIds[0], playerIds[1]), nil
+}
+
+func newTestMatchmaker() (*Matchmaker, *roomLog) {
+	log := &roomLog{rooms: make(chan []string, 8)}
+	return NewMatchmaker(nil, log.create), log
+}
+
+func ticket(playerId, queue string, rating float64, req *Request) *Ticket {
+	if req == nil {
+		req = &Request{Game: "connect4"}
+	}
+	return NewTicket(playerId, queue, rating, req)
+}
+
+func expectMatch(t *testing.T, ticket *Ticket, opponent string) {
+	select {
+	case match := <-ticket.Matched():
+		if match.Err != nil || match.Opponent != opponent {
+			t.Errorf("%s: expected a match with %s, got %+v", ticket.PlayerId, opponent, match)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("%s: expected a match with %s", ticket.PlayerId, opponent)
+	}
+}
+
+func TestPairsWithinQueue(t *testing.T) {
+	mm, log := newTestMatchmaker()
+	alice := ticket("alice", "connect4-7x6-4", 1500, nil)
+	bob := ticket("bob", "connect4-7x6-4-popout", 1500, nil)
+	carol := ticket("carol", "connect4-7x6-4", 1500, nil)
+	mm.Enqueue(alice)
+	mm.Enqueue(bob)
+	if mm.Waiting("connect4-7x6-4") != 1 || mm.Waiting("connect4-7x6-4-popout") != 1 {
+		t.Fatal("players in different queues were matched")
+	}
+	mm.Enqueue(carol)
+	expectMatch(t, alice, "carol")
+	expectMatch(t, carol, "alice")
+	if players := <-log.rooms; players[0] != "alice" || players[1] != "carol" {
+		t.Errorf("unexpected seats %v", players)
+	}
+	if mm.Waiting("connect4-7x6-4") != 0 {
+		t.Error("matched players are still queued")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	mm, _ := newTestMatchmaker()
+	strong := ticket("strong", "q", 1900, &Request{RatingRange: 200})
+	weak := ticket("weak", "q", 1500, nil)
+	picky := ticket("picky", "q", 1500, &Request{Opponent: "weak"})
+	mm.Enqueue(strong)
+	mm.Enqueue(weak)
+	if mm.Waiting("q") != 2 {
+		t.Fatal("matched players outside the rating range")
+	}
+	mm.Enqueue(picky)
+	expectMatch(t, picky, "weak")
+	expectMatch(t, weak, "picky")
+
+	near := ticket("near", "q", 1750, nil)
+	mm.Enqueue(near)
+	expectMatch(t, strong, "near")
+}
+
+func TestCancelAndRequeue(t *testing.T) {
+	mm, _ := newTestMatchmaker()
+	first := ticket("alice", "q", 1500, nil)
+	mm.Enqueue(first)
+	// Queueing again replaces the first ticket rather than pairing alice with alice.
+	second := ticket("alice", "q", 1500, nil)
+	mm.Enqueue(second)
+	if match := <-first.Matched(); match.Err == nil {
+		t.Error("the replaced ticket wasn't told")
+	}
+	if mm.Waiting("q") != 1 {
+		t.Fatalf("expected one ticket, got %d", mm.Waiting("q"))
+	}
+	if !mm.Cancel(second) || mm.Cancel(second) {
+		t.Error("cancel should only succeed while waiting")
+	}
+	if mm.Waiting("q") != 0 {
+		t.Error("cancelled ticket is still queued")
+	}
+}
@@ -0,0 +1,71 @@
+package matchmaking
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"websockets/gameroom"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Serve upgrades r and queues playerId with the Request it sends first. The socket is told when it's queued
+// and when it's matched, then closed; disconnecting before then leaves the queue.
+func (mm *Matchmaker) Serve(playerId string, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Println("UPGRADE FAILED:", err.Error())
+		return
+	}
+	defer conn.Close()
+	req := &Request{}
+	err = conn.ReadJSON(req)
+	if err != nil {
+		gameroom.CloseWithError(conn, gameroom.CloseMatchFailed, fmt.Errorf("Malformed Matchmaking Request"))
+		return
+	}
+	queue, rating, err := mm.queueFor(playerId, req)
+	if err != nil {
+		gameroom.CloseWithError(conn, gameroom.CloseMatchFailed, err)
+		return
+	}
+	ticket := NewTicket(playerId, queue, rating, req)
+	mm.Enqueue(ticket)
+	err = conn.WriteJSON(&Status{Status: StatusQueued, Game: req.Game})
+	if err != nil {
+		mm.Cancel(ticket)
+		return
+	}
+
+	// Nothing more is expected from the client, so a read only returns once it has gone away.
+	gone := make(chan bool)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(gone)
+				return
+			}
+		}
+	}()
+	select {
+	case match := <-ticket.Matched():
+		if match.Err != nil {
+			gameroom.CloseWithError(conn, gameroom.CloseMatchFailed, match.Err)
+			return
+		}
+		conn.WriteJSON(&Status{
+			Status:   StatusMatched,
+			RoomId:   match.RoomId,
+			Game:     req.Game,
+			Opponent: match.Opponent,
+		})
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	case <-gone:
+		if !mm.Cancel(ticket) {
+			// Matched just as we left. The opponent has a seat waiting; there's nobody to tell about ours.
+			fmt.Println("PLAYER " + playerId + " LEFT THE QUEUE AFTER BEING MATCHED")
+		}
+	}
+}
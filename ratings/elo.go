@@ -0,0 +1,38 @@
+package ratings
+
+import (
+	"math"
+)
+
+const (
+	Initial = 1500.0
+	// ProvisionalGames is how many games a rating moves quickly for, so new players and bots find their level.
+	ProvisionalGames = 30
+	ProvisionalK     = 40.0
+	EstablishedK     = 20.0
+)
+
+// Scores for a single game, from one player's point of view.
+const (
+	Loss = 0.0
+	Draw = 0.5
+	Win  = 1.0
+)
+
+// Expected is the score a player rated rating should average against one rated opponent.
+func Expected(rating, opponent float64) float64 {
+	return 1 / (1 + math.Pow(10, (opponent-rating)/400))
+}
+
+func K(games int) float64 {
+	if games < ProvisionalGames {
+		return ProvisionalK
+	}
+	return EstablishedK
+}
+
+// Update returns a player's new Elo rating after scoring score against opponent, having played games
+// rated games before this one.
+func Update(rating float64, games int, opponent, score float64) float64 {
+	return rating + K(games)*(score-Expected(rating, opponent))
+}
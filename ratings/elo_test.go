@@ -0,0 +1,35 @@
+package ratings
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExpected(t *testing.T) {
+	if e := Expected(1500, 1500); e != 0.5 {
+		t.Errorf("equal ratings: got %v", e)
+	}
+	if e := Expected(1900, 1500); math.Abs(e-0.909) > 0.001 {
+		t.Errorf("400 points stronger: got %v", e)
+	}
+	if e := Expected(1600, 1700) + Expected(1700, 1600); math.Abs(e-1) > 1e-9 {
+		t.Errorf("expected scores should sum to 1, got %v", e)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	if r := Update(Initial, 0, Initial, Win); r != Initial+ProvisionalK/2 {
+		t.Errorf("provisional win: got %v", r)
+	}
+	if r := Update(Initial, ProvisionalGames, Initial, Loss); r != Initial-EstablishedK/2 {
+		t.Errorf("established loss: got %v", r)
+	}
+	if r := Update(1600, 50, 1600, Draw); r != 1600 {
+		t.Errorf("draw between equals: got %v", r)
+	}
+	// Gains and losses balance when both players have the same K.
+	winner, loser := Update(1550, 40, 1450, Win), Update(1450, 40, 1550, Loss)
+	if math.Abs((winner-1550)+(loser-1450)) > 1e-9 {
+		t.Errorf("rating isn't conserved: %v, %v", winner, loser)
+	}
+}
@@ -0,0 +1,100 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"websockets/gameroom"
+)
+
+// Commands a replay viewer can send. Step is only read by Seek and counts from 0.
+const (
+	Forward = "Forward"
+	Back    = "Back"
+	Seek    = "Seek"
+)
+
+type Command struct {
+	Command string
+	Step    int
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Replay steps through the messages a game's clients were sent. It starts on the first one.
+type Replay struct {
+	updates [][]byte
+	step    int
+}
+
+func NewReplay(updates [][]byte) (*Replay, error) {
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("Nothing to replay")
+	}
+	return &Replay{
+		updates: updates,
+	}, nil
+}
+
+func (replay *Replay) Current() []byte {
+	return replay.updates[replay.step]
+}
+
+// Apply moves the replay as cmd asks, leaving it where it was if that's out of range.
+func (replay *Replay) Apply(cmd *Command) error {
+	step := replay.step
+	switch cmd.Command {
+	case Forward:
+		step += 1
+	case Back:
+		step -= 1
+	case Seek:
+		step = cmd.Step
+	default:
+		return fmt.Errorf("Unknown command %q", cmd.Command)
+	}
+	if step < 0 || step >= len(replay.updates) {
+		return fmt.Errorf("Step %d is outside the replay of %d steps", step, len(replay.updates))
+	}
+	replay.step = step
+	return nil
+}
+
+// Serve upgrades r and plays the replay to it: the exact message at the current step is sent on connecting
+// and after every command, so the same code that renders a live game can render the replay.
+func (replay *Replay) Serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Println("UPGRADE FAILED:", err.Error())
+		return
+	}
+	defer conn.Close()
+	err = conn.WriteMessage(websocket.TextMessage, replay.Current())
+	for err == nil {
+		var msg []byte
+		_, msg, err = conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		cmd := &Command{}
+		cmdErr := json.Unmarshal(msg, cmd)
+		if cmdErr == nil {
+			cmdErr = replay.Apply(cmd)
+		}
+		if cmdErr != nil {
+			err = sendError(conn, cmdErr)
+		} else {
+			err = conn.WriteMessage(websocket.TextMessage, replay.Current())
+		}
+	}
+}
+
+func sendError(conn *websocket.Conn, err error) error {
+	msg, _ := json.Marshal(&gameroom.ErrorMessage{
+		Error: err.Error(),
+	})
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
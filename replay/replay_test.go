@@ -0,0 +1,40 @@
+package replay
+
+import (
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	replay, err := NewReplay([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		cmd  Command
+		want string
+		ok   bool
+	}{
+		{Command{Command: Back}, "a", false},
+		{Command{Command: Forward}, "b", true},
+		{Command{Command: Seek, Step: 2}, "c", true},
+		{Command{Command: Forward}, "c", false},
+		{Command{Command: Seek, Step: -1}, "c", false},
+		{Command{Command: "Jump"}, "c", false},
+		{Command{Command: Back}, "b", true},
+	}
+	for i, step := range steps {
+		err := replay.Apply(&step.cmd)
+		if (err == nil) != step.ok {
+			t.Errorf("step %d: got error %v, want ok=%v", i, err, step.ok)
+		}
+		if string(replay.Current()) != step.want {
+			t.Errorf("step %d: got %q, want %q", i, replay.Current(), step.want)
+		}
+	}
+}
+
+func TestEmptyReplay(t *testing.T) {
+	if _, err := NewReplay(nil); err == nil {
+		t.Fatal("expected an error replaying no updates")
+	}
+}
@@ -1,19 +1,27 @@
 var socket = null;
 var userId = null;
+var roomId = null;
+var spectating = false;
+var replaying = false;
+var boardWidth = 0;
+var boardHeight = 0;
 var rematchSent = false;
 var gameOver = false;
+var clocks = null;
 var pieceColor = {
 	0: "red",
 	1: "black",
 };
 
-function reset_board() {
+function reset_board(width, height) {
+	boardWidth = width;
+	boardHeight = height;
 	$('#game').empty();
-	$('#game').append('<div class="row"><div id="sidebar" class="col-2"><span id="turn_label"></p></div><div class="col-10"><table id="connect4"></table></div></div>');
-	for(var i = 0; i < 6; i += 1) {
-		$('#connect4').append('<tr id="row_' + (5 - i).toString() + '" class="c4row"></tr>');
+	$('#game').append('<div class="row"><div id="sidebar" class="col-2"><p id="turn_label"></p><p id="series"></p><p id="clocks"></p><p id="commands"></p><p id="spectators"></p><p id="notation"></p></div><div class="col-10"><table id="connect4"></table></div></div>');
+	for(var i = 0; i < height; i += 1) {
+		$('#connect4').append('<tr id="row_' + (height - 1 - i).toString() + '" class="c4row"></tr>');
 	}
-	for(var i = 0; i < 7; i += 1) {
+	for(var i = 0; i < width; i += 1) {
 		$('.c4row').append('<td class="c4col col_' + i.toString() + '"></td>');
 	}
 	$('.c4col').click(make_move);
@@ -21,16 +29,190 @@ function reset_board() {
 	$('.c4col').css('height', '100px');
 	$('.c4col').css('border', '1px solid black');
 	$('.c4col').css('border-radius', '50%');
+	if(replaying) {
+		$('#sidebar').append('<input type="button" onclick="replay_command(\'Back\')" value="&lt;"> <input type="button" onclick="replay_command(\'Forward\')" value="&gt;"><br>Step: <input id="seek" type="number" min="0" style="width: 4em"> <input type="button" onclick="replay_seek()" value="Seek">');
+	}
 }
 
-function connect_four() {
-	userId = $('#userId').val().trim();
-	if(userId == '') {
-		alert("Must input User Id");
+function connect_four(room, watch) {
+	if(userId == null) {
+		alert("Must log in first");
 	} else {
-		reset_board();
-		socket = connect_socket(userId);
+		roomId = room;
+		spectating = !!watch;
+		replaying = false;
+		socket = connect_socket();
+	}
+}
+
+function logged_in(id) {
+	userId = id;
+	$('#accountId').text(id);
+	$('#login').hide();
+	$('#account').show();
+}
+
+function account_request(path) {
+	var req = { Id: $('#userId').val().trim(), Password: $('#password').val() };
+	return fetch(path, { method: 'POST', body: JSON.stringify(req) })
+		.then(function(resp) {
+			if(!resp.ok) {
+				return resp.text().then(function(text) { throw new Error(text); });
+			}
+			return resp.json();
+		});
+}
+
+function login() {
+	account_request('/login')
+		.then(function(account) {
+			$('#password').val('');
+			logged_in(account.Id);
+		})
+		.catch(function(err) {
+			alert("Error: " + err.message);
+		});
+}
+
+function register() {
+	account_request('/register')
+		.then(login)
+		.catch(function(err) {
+			alert("Error: " + err.message);
+		});
+}
+
+function logout() {
+	fetch('/logout', { method: 'POST' })
+		.then(function() {
+			userId = null;
+			$('#login').show();
+			$('#account').hide();
+		});
+}
+
+function check_login() {
+	fetch('/login')
+		.then(function(resp) {
+			if(resp.ok) {
+				resp.json().then(function(account) { logged_in(account.Id); });
+			}
+		});
+}
+
+function room_options() {
+	return {
+		Width: parseInt($('#width').val()),
+		Height: parseInt($('#height').val()),
+		Connect: parseInt($('#connect').val()),
+		PopOut: $('#popout').is(':checked'),
+		TimeControl: time_control(),
+		RandomColors: $('#randomColors').is(':checked'),
+		BestOf: parseInt($('#bestOf').val()) || 0,
+	};
+}
+
+function time_control() {
+	var kind = $('#clock').val();
+	var minutes = parseFloat($('#minutes').val()) || 0;
+	var seconds = parseFloat($('#seconds').val()) || 0;
+	return {
+		Kind: kind,
+		Initial: Math.round(minutes * 60000),
+		Increment: kind == 'Fischer' ? Math.round(seconds * 1000) : 0,
+		PerMove: kind == 'PerMove' ? Math.round(seconds * 1000) : 0,
+	};
+}
+
+function format_time(ms) {
+	var seconds = Math.max(0, Math.ceil(ms / 1000));
+	var minutes = Math.floor(seconds / 60);
+	seconds = seconds % 60;
+	return minutes + ':' + (seconds < 10 ? '0' : '') + seconds;
+}
+
+// draw_clocks counts the player to move's time down locally between updates from the server.
+function draw_clocks() {
+	if(clocks == null) {
+		$('#clocks').text('');
+		return;
+	}
+	var text = [];
+	for(var color = 0; color < clocks.remaining.length; color += 1) {
+		var left = clocks.remaining[color];
+		if(clocks.running && color == clocks.turn) {
+			left -= Date.now() - clocks.received;
+		}
+		text.push(pieceColor[color] + ' ' + format_time(left));
 	}
+	$('#clocks').text(text.join(' | '));
+}
+
+// find_match queues for a game with the chosen options and joins the room it's matched into.
+function find_match() {
+	if(userId == null) {
+		alert("Must log in first");
+		return;
+	}
+	var queue = new WebSocket('ws://localhost:8080/matchmake');
+	queue.onopen = function() {
+		queue.send(JSON.stringify({ Game: 'connect4', Options: room_options(), Opponent: $('#opponent').val().trim() }));
+	};
+	queue.onmessage = function(event) {
+		var status = JSON.parse(event.data);
+		if(status.Error) {
+			alert("Error: " + status.Error);
+		} else if(status.Status == 'Queued') {
+			$('#queue_status').text('Waiting for an opponent...');
+		} else if(status.Status == 'Matched') {
+			$('#queue_status').text('Playing ' + status.Opponent);
+			connect_four(status.RoomId);
+		}
+	};
+}
+
+function create_room() {
+	var options = room_options();
+	options.Moves = $('#moves').val().trim();
+	fetch('/rooms', { method: 'POST', body: JSON.stringify({ Game: 'connect4', Options: options }) })
+		.then(function(resp) {
+			if(!resp.ok) {
+				return resp.text().then(function(text) { throw new Error(text); });
+			}
+			return resp;
+		})
+		.then(function(resp) { return resp.json(); })
+		.then(function(room) { connect_four(room.Id); })
+		.catch(function(err) { alert(err.message); });
+}
+
+function list_rooms() {
+	fetch('/rooms')
+		.then(function(resp) { return resp.json(); })
+		.then(function(rooms) {
+			$('#rooms').empty();
+			for(var i = 0; i < rooms.length; i += 1) {
+				var id = rooms[i].Id;
+				$('#rooms').append('<li>' + id + ' [' + rooms[i].Game + '] (' + rooms[i].Players + ' connected) <input type="button" onclick="connect_four(\'' + id + '\')" value="Join"> <input type="button" onclick="connect_four(\'' + id + '\', true)" value="Watch"></li>');
+			}
+		});
+}
+
+// show_leaderboard lists the best players of the variant the room options describe.
+function show_leaderboard() {
+	var gameType = 'connect4-' + $('#width').val() + 'x' + $('#height').val() + '-' + $('#connect').val();
+	if($('#popout').is(':checked')) {
+		gameType += '-popout';
+	}
+	fetch('/leaderboard?game=' + encodeURIComponent(gameType))
+		.then(function(resp) { return resp.json(); })
+		.then(function(board) {
+			$('#leaderboard').empty();
+			for(var i = 0; i < board.length; i += 1) {
+				var r = board[i];
+				$('#leaderboard').append('<li>' + r.PlayerId + ' ' + Math.round(r.Rating) + ' (' + r.Wins + '-' + r.Losses + '-' + r.Draws + ')</li>');
+			}
+		});
 }
 
 function build_selector_str(row, col) {
@@ -44,41 +226,25 @@ function row_col(row, col) {
 }
 
 function connect_socket() {
-	var socket = new WebSocket('ws://localhost:8080/game?userId=' + userId);
+	var url = 'ws://localhost:8080/game/' + roomId;
+	if(spectating) {
+		url += '?spectate=true';
+	}
+	var socket = new WebSocket(url);
 	socket.onmessage = function(event) {
 		console.log(event.data);
 		var board = JSON.parse(event.data);
-		if(rematchSent && !board.GameOver) {
-			reset_board();
-			rematchSent = false;
-			gameOver = false;
-		}
-		$('#turn_label').text("Current Turn: " + pieceColor[board.CurrentTurn]);
-		$('#turn_label').css('color', pieceColor[board.CurrentTurn]);
-		$('.c4col').css('background-color', null);
-		for(var col = 0; col < board.Columns.length; col += 1) {
-			for(var row = 0; row < board.Columns[col].length; row += 1) {
-				var piece = board.Columns[col][row];
-				var color = '';
-				if(piece == 0) {
-					color = 'red';
-				} else {
-					color = 'black';
-				}
-				row_col(row, col).css('background-color', color);
-			}
-		}
-		if(board.GameOver && !gameOver) {
-			gameOver = true;
-			for(var i = 0; i < board.WinningPositions.length; i += 1) {
-				row_col(board.WinningPositions[i].Row, board.WinningPositions[i].Col).css('border', '2px dashed green');
-			}
-			$('#sidebar').append('<input type="button" onclick="attempt_rematch()" value="Attempt Rematch">');
+		if(board.Error) {
+			alert("Error: " + board.Error);
+			return;
 		}
+		render_update(board);
 	};
 
 	socket.onclose = function(event) {
-		alert("Socket Closed");
+		if(event.code < 4000) {
+			alert("Socket Closed" + (event.reason ? ": " + event.reason : ""));
+		}
 	};
 
 	socket.onopen = function(event) {
@@ -87,8 +253,110 @@ function connect_socket() {
 	return socket;
 }
 
+function watch_replay() {
+	var gameId = $('#replayId').val().trim();
+	if(gameId == '') {
+		alert("Must input Game Id");
+		return;
+	}
+	spectating = true;
+	replaying = true;
+	boardWidth = 0;
+	socket = new WebSocket('ws://localhost:8080/replay/' + gameId);
+	socket.onmessage = function(event) {
+		var board = JSON.parse(event.data);
+		if(board.Error) {
+			console.log(board.Error);
+			return;
+		}
+		render_update(board);
+	};
+	socket.onclose = function(event) {
+		if(event.reason) {
+			alert("Replay Closed: " + event.reason);
+		}
+	};
+}
+
+function replay_command(command) {
+	socket.send(JSON.stringify({ Command: command }));
+}
+
+function replay_seek() {
+	socket.send(JSON.stringify({ Command: 'Seek', Step: parseInt($('#seek').val()) }));
+}
+
+function render_update(board) {
+	if(board.Width != boardWidth || board.Height != boardHeight || (gameOver && !board.GameOver)) {
+		reset_board(board.Width, board.Height);
+		rematchSent = false;
+		gameOver = false;
+		if(board.PopOut) {
+			$('#sidebar').append('<p>Pop Out: shift-click a column to pop your piece from the bottom.</p>');
+		}
+	}
+	$('#turn_label').text("Current Turn: " + pieceColor[board.CurrentTurn]);
+	$('#turn_label').css('color', pieceColor[board.CurrentTurn]);
+	$('#notation').text(board.Notation ? "Moves: " + board.Notation : '');
+	clocks = null;
+	if(board.Remaining && board.Remaining.length > 0) {
+		clocks = {
+			remaining: board.Remaining,
+			turn: board.CurrentTurn,
+			received: Date.now(),
+			running: !board.GameOver && !replaying && Object.keys(board.Players).length == 2,
+		};
+	}
+	draw_clocks();
+	show_series(board);
+	if(board.Spectators && board.Spectators.length > 0) {
+		$('#spectators').text("Watching: " + board.Spectators.join(', '));
+	} else {
+		$('#spectators').text('');
+	}
+	$('.c4col').css('background-color', '');
+	for(var col = 0; col < board.Columns.length; col += 1) {
+		for(var row = 0; row < board.Columns[col].length; row += 1) {
+			var piece = board.Columns[col][row];
+			var color = '';
+			if(piece == 0) {
+				color = 'red';
+			} else {
+				color = 'black';
+			}
+			row_col(row, col).css('background-color', color);
+		}
+	}
+	show_commands(board);
+	if(board.GameOver && board.Result) {
+		if(board.Result.Reason == 'Aborted') {
+			$('#turn_label').text("Aborted");
+			$('#turn_label').css('color', 'gray');
+		} else if(board.Result.Draw) {
+			$('#turn_label').text("Draw (" + board.Result.Reason + ")");
+			$('#turn_label').css('color', 'gray');
+		} else {
+			$('#turn_label').text("Winner: " + board.Result.WinnerId + " (" + board.Result.Reason + ")");
+			$('#turn_label').css('color', pieceColor[board.Result.WinnerColor]);
+		}
+	}
+	if(board.GameOver && !gameOver) {
+		gameOver = true;
+		for(var i = 0; board.WinningPositions && i < board.WinningPositions.length; i += 1) {
+			row_col(board.WinningPositions[i].Row, board.WinningPositions[i].Col).css('border', '2px dashed green');
+		}
+		if(spectating) {
+			return;
+		}
+		$('#sidebar').append('<input type="button" onclick="attempt_rematch()" value="Attempt Rematch">');
+	}
+}
+
 
 function make_move(event) {
+	if(spectating) {
+		return;
+	}
 	var classes = event.target.className.split(/\s+/);
 	var colClicked = -1;
 	for(var i = 0; i < classes.length; i += 1) {
@@ -96,15 +364,65 @@ function make_move(event) {
 			colClicked = parseInt(classes[i].slice(4));
 		}
 	}
-	if(colClicked < 0 || colClicked > 6) {
+	if(colClicked < 0 || colClicked >= boardWidth) {
 		return;
 	}
-	var turn = { Col: colClicked };
+	// Shift-click pops your own piece out of the bottom of the column.
+	var turn = { Col: colClicked, Pop: event.shiftKey };
 	socket.send(JSON.stringify(turn));
 }
 
+// show_series gives the score of a best-of-N series, with each player's color this game.
+function show_series(board) {
+	if(!board.Series) {
+		$('#series').text('');
+		return;
+	}
+	var series = board.Series;
+	var score = [];
+	for(var player in board.Players) {
+		score.push(player + ' (' + pieceColor[board.Players[player]] + ') ' + (series.Wins[player] || 0));
+	}
+	var text = 'Best of ' + series.BestOf + ', game ' + series.Game + ': ' + score.join(' - ');
+	if(series.Draws > 0) {
+		text += ', ' + series.Draws + ' drawn';
+	}
+	if(series.Over) {
+		text += series.WinnerId ? '. ' + series.WinnerId + ' wins the series' : '. Series tied';
+	}
+	$('#series').text(text);
+}
+
+// show_commands offers the player whatever they can do besides moving: resign, abort, and offer or answer draws.
+function show_commands(board) {
+	$('#commands').empty();
+	if(spectating || replaying || board.GameOver) {
+		return;
+	}
+	if(board.DrawOffer && board.DrawOffer != userId) {
+		$('#commands').append(board.DrawOffer + ' offers a draw <input type="button" onclick="send_command(\'AcceptDraw\')" value="Accept"> <input type="button" onclick="send_command(\'DeclineDraw\')" value="Decline"><br>');
+	} else if(board.DrawOffer == userId) {
+		$('#commands').append('Draw offered<br>');
+	} else {
+		$('#commands').append('<input type="button" onclick="send_command(\'OfferDraw\')" value="Offer Draw"> ');
+	}
+	$('#commands').append('<input type="button" onclick="send_command(\'Resign\')" value="Resign">');
+	if(board.History.length <= board.FirstPly) {
+		$('#commands').append(' <input type="button" onclick="send_command(\'Abort\')" value="Abort">');
+	}
+}
+
+function send_command(name) {
+	var command = {};
+	command[name] = true;
+	socket.send(JSON.stringify(command));
+}
+
 function attempt_rematch() {
 	var rematch = {Rematch: true};
 	rematchSent = true;
 	socket.send(JSON.stringify(rematch));
 }
+list_rooms();
+check_login();
+setInterval(draw_clocks, 200);
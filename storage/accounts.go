@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"time"
+)
+
+var ErrAccountExists = fmt.Errorf("Account already exists")
+
+// Account is a registered player or bot. PasswordHash is never sent to clients.
+type Account struct {
+	Id           string
+	PasswordHash []byte `json:"-"`
+	Bot          bool
+	CreatedAt    time.Time
+}
+
+func (store *Store) CreateAccount(account *Account) error {
+	_, err := store.db.Exec(`INSERT INTO accounts (id, password_hash, bot, created_at) VALUES (?, ?, ?, ?)`,
+		account.Id, string(account.PasswordHash), account.Bot, account.CreatedAt)
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+		return ErrAccountExists
+	}
+	return err
+}
+
+func (store *Store) Account(id string) (*Account, error) {
+	account := &Account{}
+	var hash string
+	err := store.db.QueryRow(`SELECT id, password_hash, bot, created_at FROM accounts WHERE id = ?`, id).
+		Scan(&account.Id, &hash, &account.Bot, &account.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("No account %q", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	account.PasswordHash = []byte(hash)
+	return account, nil
+}
+
+// AddAPIKey stores the hash of a key that authenticates as accountId. The key itself is never stored.
+func (store *Store) AddAPIKey(accountId, keyHash string) error {
+	_, err := store.db.Exec(`INSERT INTO api_keys (key_hash, account_id, created_at) VALUES (?, ?, ?)`, keyHash, accountId, time.Now())
+	return err
+}
+
+// APIKeyAccount returns the id of the account the key with keyHash belongs to.
+func (store *Store) APIKeyAccount(keyHash string) (string, error) {
+	var accountId string
+	err := store.db.QueryRow(`SELECT account_id FROM api_keys WHERE key_hash = ?`, keyHash).Scan(&accountId)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("Unknown API key")
+	}
+	return accountId, err
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+	ctypes "websockets/games/connect4/types"
+	"websockets/ratings"
+)
+
+// Rating is a player's Elo rating for one game type.
+type Rating struct {
+	PlayerId string
+	GameType string
+	Rating   float64
+	Games    int
+	Wins     int
+	Losses   int
+	Draws    int
+}
+
+// RatingType is the game type Connect4 games with options are rated under; each variant has its own ratings.
+func RatingType(options ctypes.Options) string {
+	return "connect4-" + options.Variant()
+}
+
+const ratingColumns = `player_id, game_type, rating, games, wins, losses, draws`
+
+func scanRating(row scanner) (*Rating, error) {
+	rating := &Rating{}
+	err := row.Scan(&rating.PlayerId, &rating.GameType, &rating.Rating, &rating.Games, &rating.Wins, &rating.Losses, &rating.Draws)
+	return rating, err
+}
+
+func loadRating(tx *sql.Tx, playerId, gameType string) (*Rating, error) {
+	rating, err := scanRating(tx.QueryRow(`SELECT `+ratingColumns+` FROM ratings WHERE player_id = ? AND game_type = ?`, playerId, gameType))
+	if err == sql.ErrNoRows {
+		return &Rating{
+			PlayerId: playerId,
+			GameType: gameType,
+			Rating:   ratings.Initial,
+		}, nil
+	}
+	return rating, err
+}
+
+// rateGame updates the ratings of a two player game's players. Anything else, and aborted games, aren't rated.
+// Nor are games started from imported moves, since whoever set the position up could hand their opponent a
+// lost one.
+func rateGame(tx *sql.Tx, gameType string, record *ctypes.GameRecord) error {
+	if len(record.Players) != 2 || record.Result.Reason == ctypes.ReasonAborted || record.FirstPly > 0 {
+		return nil
+	}
+	players := []*Rating{}
+	for playerId := range record.Players {
+		rating, err := loadRating(tx, playerId, gameType)
+		if err != nil {
+			return err
+		}
+		players = append(players, rating)
+	}
+	newRatings := make([]float64, len(players))
+	for i, player := range players {
+		opponent := players[1-i]
+		score := ratings.Loss
+		switch {
+		case record.Result.Draw:
+			score = ratings.Draw
+			player.Draws += 1
+		case record.Result.WinnerId == player.PlayerId:
+			score = ratings.Win
+			player.Wins += 1
+		default:
+			player.Losses += 1
+		}
+		newRatings[i] = ratings.Update(player.Rating, player.Games, opponent.Rating, score)
+	}
+	for i, player := range players {
+		player.Rating = newRatings[i]
+		player.Games += 1
+		_, err := tx.Exec(`INSERT OR REPLACE INTO ratings (`+ratingColumns+`, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			player.PlayerId, player.GameType, player.Rating, player.Games, player.Wins, player.Losses, player.Draws, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *Store) queryRatings(query string, args ...interface{}) ([]*Rating, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	toret := []*Rating{}
+	for rows.Next() {
+		rating, err := scanRating(rows)
+		if err != nil {
+			return nil, err
+		}
+		toret = append(toret, rating)
+	}
+	return toret, rows.Err()
+}
+
+// Leaderboard returns the limit highest rated players of gameType, best first.
+func (store *Store) Leaderboard(gameType string, limit int) ([]*Rating, error) {
+	return store.queryRatings(`SELECT `+ratingColumns+` FROM ratings WHERE game_type = ? ORDER BY rating DESC, games DESC LIMIT ?`, gameType, limit)
+}
+
+// PlayerRatings returns playerId's rating in every game type they've played.
+func (store *Store) PlayerRatings(playerId string) ([]*Rating, error) {
+	return store.queryRatings(`SELECT `+ratingColumns+` FROM ratings WHERE player_id = ? ORDER BY game_type`, playerId)
+}
+
+// PlayerRating returns playerId's rating for gameType, or the initial rating if they haven't played it.
+func (store *Store) PlayerRating(playerId, gameType string) (float64, error) {
+	var rating float64
+	err := store.db.QueryRow(`SELECT rating FROM ratings WHERE player_id = ? AND game_type = ?`, playerId, gameType).Scan(&rating)
+	if err == sql.ErrNoRows {
+		return ratings.Initial, nil
+	}
+	return rating, err
+}
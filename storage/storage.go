@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"time"
+	ctypes "websockets/games/connect4/types"
+)
+
+// migrations are applied in order, each exactly once. Only ever append to this list.
+var migrations = []string{
+	`CREATE TABLE rooms (
+		id         TEXT PRIMARY KEY,
+		game_type  TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE TABLE games (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		room_id      TEXT NOT NULL REFERENCES rooms(id),
+		width        INTEGER NOT NULL,
+		height       INTEGER NOT NULL,
+		connect      INTEGER NOT NULL,
+		pop_out      BOOLEAN NOT NULL,
+		winner_id    TEXT NOT NULL,
+		winner_color INTEGER NOT NULL,
+		draw         BOOLEAN NOT NULL,
+		reason       TEXT NOT NULL,
+		started_at   TIMESTAMP NOT NULL,
+		ended_at     TIMESTAMP NOT NULL
+	);
+	CREATE TABLE game_players (
+		game_id   INTEGER NOT NULL REFERENCES games(id),
+		player_id TEXT NOT NULL,
+		color     INTEGER NOT NULL,
+		PRIMARY KEY (game_id, player_id)
+	);
+	CREATE INDEX game_players_player_id ON game_players(player_id);
+	CREATE TABLE moves (
+		game_id   INTEGER NOT NULL REFERENCES games(id),
+		ply       INTEGER NOT NULL,
+		player_id TEXT NOT NULL,
+		color     INTEGER NOT NULL,
+		col       INTEGER NOT NULL,
+		pop       BOOLEAN NOT NULL,
+		played_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (game_id, ply)
+	);`,
+	`CREATE TABLE updates (
+		game_id INTEGER NOT NULL REFERENCES games(id),
+		seq     INTEGER NOT NULL,
+		payload TEXT NOT NULL,
+		PRIMARY KEY (game_id, seq)
+	);`,
+	`CREATE TABLE accounts (
+		id            TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		bot           BOOLEAN NOT NULL,
+		created_at    TIMESTAMP NOT NULL
+	);
+	CREATE TABLE api_keys (
+		key_hash   TEXT PRIMARY KEY,
+		account_id TEXT NOT NULL REFERENCES accounts(id),
+		created_at TIMESTAMP NOT NULL
+	);`,
+	`CREATE TABLE ratings (
+		player_id  TEXT NOT NULL,
+		game_type  TEXT NOT NULL,
+		rating     REAL NOT NULL,
+		games      INTEGER NOT NULL,
+		wins       INTEGER NOT NULL,
+		losses     INTEGER NOT NULL,
+		draws      INTEGER NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (player_id, game_type)
+	);
+	CREATE INDEX ratings_game_type ON ratings(game_type, rating);`,
+	`ALTER TABLE games ADD COLUMN time_control TEXT NOT NULL DEFAULT '{}';`,
+	`ALTER TABLE games ADD COLUMN first_ply INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE games ADD COLUMN best_of INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE games ADD COLUMN series_game INTEGER NOT NULL DEFAULT 0;`,
+}
+
+// StoredGame is a finished game as read back from the database.
+type StoredGame struct {
+	Id     int64
+	RoomId string
+	ctypes.GameRecord
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens or creates the SQLite database at path and brings its schema up to date.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=1")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; sharing one connection avoids "database is locked" errors.
+	db.SetMaxOpenConns(1)
+	store := &Store{
+		db: db,
+	}
+	err = store.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+func (store *Store) migrate() error {
+	_, err := store.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = store.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	for ; version < len(migrations); version += 1 {
+		tx, err := store.db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(migrations[version])
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version+1, time.Now())
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Migration %d failed: %s", version+1, err.Error())
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *Store) RecordRoom(roomId, gameType string) error {
+	_, err := store.db.Exec(`INSERT OR IGNORE INTO rooms (id, game_type, created_at) VALUES (?, ?, ?)`, roomId, gameType, time.Now())
+	return err
+}
+
+// RecordGame saves a finished Connect4 game played in roomId, which must already have been recorded with RecordRoom,
+// and updates its players' ratings.
+func (store *Store) RecordGame(roomId string, record *ctypes.GameRecord) (int64, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	id, err := recordGame(tx, roomId, record)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func recordGame(tx *sql.Tx, roomId string, record *ctypes.GameRecord) (int64, error) {
+	timeControl, err := json.Marshal(record.TimeControl)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`INSERT INTO games
+		(room_id, width, height, connect, pop_out, time_control, first_ply, best_of, series_game,
+		 winner_id, winner_color, draw, reason, started_at, ended_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		roomId, record.Width, record.Height, record.Connect, record.PopOut, string(timeControl),
+		record.FirstPly, record.BestOf, record.SeriesGame,
+		record.Result.WinnerId, int(record.Result.WinnerColor), record.Result.Draw, string(record.Result.Reason),
+		record.StartedAt, record.EndedAt)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for playerId, color := range record.Players {
+		_, err = tx.Exec(`INSERT INTO game_players (game_id, player_id, color) VALUES (?, ?, ?)`, id, playerId, int(color))
+		if err != nil {
+			return 0, err
+		}
+	}
+	for ply, move := range record.History {
+		_, err = tx.Exec(`INSERT INTO moves (game_id, ply, player_id, color, col, pop, played_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, ply, move.PlayerId, int(move.Color), move.Col, move.Pop, move.Time)
+		if err != nil {
+			return 0, err
+		}
+	}
+	for seq, update := range record.Updates {
+		_, err = tx.Exec(`INSERT INTO updates (game_id, seq, payload) VALUES (?, ?, ?)`, id, seq, string(update))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, rateGame(tx, RatingType(record.Options), record)
+}
+
+// Updates returns the messages clients of game id were sent, in order.
+func (store *Store) Updates(id int64) ([][]byte, error) {
+	rows, err := store.db.Query(`SELECT payload FROM updates WHERE game_id = ? ORDER BY seq`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	toret := [][]byte{}
+	for rows.Next() {
+		var payload string
+		err = rows.Scan(&payload)
+		if err != nil {
+			return nil, err
+		}
+		toret = append(toret, []byte(payload))
+	}
+	return toret, rows.Err()
+}
+
+const gameColumns = `g.id, g.room_id, g.width, g.height, g.connect, g.pop_out, g.time_control,
+	g.first_ply, g.best_of, g.series_game, g.winner_id, g.winner_color, g.draw, g.reason, g.started_at, g.ended_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanGame(row scanner) (*StoredGame, error) {
+	game := &StoredGame{}
+	var winnerColor int
+	var reason, timeControl string
+	err := row.Scan(&game.Id, &game.RoomId, &game.Width, &game.Height, &game.Connect, &game.PopOut, &timeControl,
+		&game.FirstPly, &game.BestOf, &game.SeriesGame, &game.Result.WinnerId, &winnerColor, &game.Result.Draw, &reason, &game.StartedAt, &game.EndedAt)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(timeControl), &game.TimeControl)
+	if err != nil {
+		return nil, err
+	}
+	game.Result.WinnerColor = ctypes.Color(winnerColor)
+	game.Result.Reason = ctypes.Reason(reason)
+	return game, nil
+}
+
+// PlayerHistory returns up to limit of playerId's finished games, most recent first.
+func (store *Store) PlayerHistory(playerId string, limit int) ([]*StoredGame, error) {
+	rows, err := store.db.Query(`SELECT `+gameColumns+`
+		FROM games g JOIN game_players p ON p.game_id = g.id
+		WHERE p.player_id = ?
+		ORDER BY g.ended_at DESC, g.id DESC
+		LIMIT ?`, playerId, limit)
+	if err != nil {
+		return nil, err
+	}
+	toret := []*StoredGame{}
+	for rows.Next() {
+		game, err := scanGame(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		toret = append(toret, game)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, game := range toret {
+		err = store.loadDetails(game)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return toret, nil
+}
+
+// Game returns a single finished game by id.
+func (store *Store) Game(id int64) (*StoredGame, error) {
+	game, err := scanGame(store.db.QueryRow(`SELECT `+gameColumns+` FROM games g WHERE g.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("No game with id %d", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return game, store.loadDetails(game)
+}
+
+func (store *Store) loadDetails(game *StoredGame) error {
+	game.Players = map[string]ctypes.Color{}
+	rows, err := store.db.Query(`SELECT player_id, color FROM game_players WHERE game_id = ?`, game.Id)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var playerId string
+		var color int
+		err = rows.Scan(&playerId, &color)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		game.Players[playerId] = ctypes.Color(color)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	game.History = []ctypes.MoveRecord{}
+	rows, err = store.db.Query(`SELECT player_id, color, col, pop, played_at FROM moves WHERE game_id = ? ORDER BY ply`, game.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		move := ctypes.MoveRecord{}
+		var color int
+		err = rows.Scan(&move.PlayerId, &color, &move.Col, &move.Pop, &move.Time)
+		if err != nil {
+			return err
+		}
+		move.Color = ctypes.Color(color)
+		game.History = append(game.History, move)
+	}
+	return rows.Err()
+}
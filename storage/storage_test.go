@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	ctypes "websockets/games/connect4/types"
+	"websockets/ratings"
+)
+
+func openTestStore(t *testing.T) (*Store, string, func()) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "games.db")
+	store, err := Open(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, path, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testRecord(winner string, ended time.Time) *ctypes.GameRecord {
+	return &ctypes.GameRecord{
+		Options: ctypes.DefaultOptions(),
+		Players: map[string]ctypes.Color{"alice": ctypes.Red, "bob": ctypes.Black},
+		History: []ctypes.MoveRecord{
+			{PlayerId: "alice", Color: ctypes.Red, Col: 3, Time: ended.Add(-2 * time.Second)},
+			{PlayerId: "bob", Color: ctypes.Black, Col: 4, Time: ended.Add(-time.Second)},
+		},
+		Result: ctypes.Result{
+			WinnerId:    winner,
+			WinnerColor: ctypes.Red,
+			Reason:      ctypes.ReasonConnected,
+		},
+		StartedAt: ended.Add(-time.Minute),
+		EndedAt:   ended,
+		Updates:   [][]byte{[]byte(`{"CurrentTurn":1}`), []byte(`{"CurrentTurn":0}`)},
+	}
+}
+
+func TestRecordAndQueryHistory(t *testing.T) {
+	store, path, cleanup := openTestStore(t)
+	defer cleanup()
+
+	if err := store.RecordRoom("room", "connect4"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	first := testRecord("alice", now.Add(-time.Hour))
+	first.TimeControl = ctypes.TimeControl{Kind: ctypes.ClockFischer, Initial: 60000, Increment: 2000}
+	first.FirstPly = 1
+	first.BestOf = 3
+	first.SeriesGame = 2
+	if _, err := store.RecordGame("room", first); err != nil {
+		t.Fatal(err)
+	}
+	rematch, err := store.RecordGame("room", testRecord("bob", now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := store.PlayerHistory("bob", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Id != rematch || history[0].Result.WinnerId != "bob" {
+		t.Fatalf("expected the rematch first, got %+v", history)
+	}
+	game := history[1]
+	if game.RoomId != "room" || game.Players["alice"] != ctypes.Red || len(game.History) != 2 || game.History[1].Col != 4 {
+		t.Errorf("unexpected stored game %+v", game)
+	}
+	if game.TimeControl.Kind != ctypes.ClockFischer || game.TimeControl.Increment != 2000 {
+		t.Errorf("unexpected time control %+v", game.TimeControl)
+	}
+	if game.FirstPly != 1 || game.BestOf != 3 || game.SeriesGame != 2 {
+		t.Errorf("expected the imported moves and series to be kept, got %+v", game.GameRecord)
+	}
+	if rematch := history[0]; rematch.FirstPly != 0 || rematch.BestOf != 0 || rematch.SeriesGame != 0 {
+		t.Errorf("expected an ordinary game, got %+v", rematch.GameRecord)
+	}
+	if missing, _ := store.PlayerHistory("carol", 10); len(missing) != 0 {
+		t.Errorf("expected no games for carol, got %d", len(missing))
+	}
+
+	// Reopening must not reapply migrations.
+	store.Close()
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if _, err := reopened.Game(rematch); err != nil {
+		t.Error(err)
+	}
+	updates, err := reopened.Updates(rematch)
+	if err != nil || len(updates) != 2 || string(updates[1]) != `{"CurrentTurn":0}` {
+		t.Errorf("unexpected updates %q (%v)", updates, err)
+	}
+}
+
+func TestRatings(t *testing.T) {
+	store, _, cleanup := openTestStore(t)
+	defer cleanup()
+
+	if err := store.RecordRoom("room", "connect4"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, winner := range []string{"alice", "alice", "bob"} {
+		if _, err := store.RecordGame("room", testRecord(winner, now)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aborted := testRecord("", now)
+	aborted.Result = ctypes.Result{WinnerColor: ctypes.NoColor, Reason: ctypes.ReasonAborted}
+	if _, err := store.RecordGame("room", aborted); err != nil {
+		t.Fatal(err)
+	}
+	imported := testRecord("alice", now)
+	imported.FirstPly = 1
+	if _, err := store.RecordGame("room", imported); err != nil {
+		t.Fatal(err)
+	}
+	popOut := testRecord("", now)
+	popOut.PopOut = true
+	popOut.Result.Draw = true
+	if _, err := store.RecordGame("room", popOut); err != nil {
+		t.Fatal(err)
+	}
+
+	board, err := store.Leaderboard(RatingType(ctypes.DefaultOptions()), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(board) != 2 || board[0].PlayerId != "alice" || board[1].PlayerId != "bob" {
+		t.Fatalf("unexpected leaderboard %+v", board)
+	}
+	alice, bob := board[0], board[1]
+	if alice.Games != 3 || alice.Wins != 2 || alice.Losses != 1 || bob.Wins != 1 || bob.Losses != 2 {
+		t.Errorf("unexpected records %+v %+v", alice, bob)
+	}
+	if alice.Rating <= ratings.Initial || bob.Rating >= ratings.Initial {
+		t.Errorf("unexpected ratings %v %v", alice.Rating, bob.Rating)
+	}
+
+	// Variants are rated separately.
+	mine, err := store.PlayerRatings("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mine) != 2 || mine[1].GameType != "connect4-7x6-4-popout" || mine[1].Draws != 1 || mine[1].Rating != ratings.Initial {
+		t.Errorf("unexpected ratings %+v", mine)
+	}
+}
@@ -1,26 +1,385 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/gorilla/websocket"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"websockets/auth"
 	"websockets/gameroom"
+	"websockets/games"
 	"websockets/games/connect4"
+	ctypes "websockets/games/connect4/types"
+	_ "websockets/games/echo"
+	"websockets/games/types"
+	"websockets/lobby"
+	"websockets/matchmaking"
+	"websockets/ratings"
+	"websockets/replay"
+	"websockets/storage"
 )
 
-var game *gameroom.GameRoom
+type accountRequest struct {
+	Id       string
+	Password string
+	Bot      bool
+}
+
+type accountResponse struct {
+	Id     string
+	Token  string `json:",omitempty"`
+	APIKey string `json:",omitempty"`
+}
+
+type createRoomRequest struct {
+	Game    string
+	Options json.RawMessage
+}
+
+var rooms *lobby.Lobby
+var store *storage.Store
+var accounts *auth.Auth
+var matchmaker *matchmaking.Matchmaker
 
 func gameConnect(w http.ResponseWriter, r *http.Request) {
+	roomId := strings.TrimPrefix(r.URL.Path, "/game/")
+	room, err := rooms.Room(roomId)
+	if err != nil {
+		gameroom.Reject(w, r, gameroom.CloseNoRoom, err)
+		return
+	}
+	userId, err := accounts.Authenticate(r)
+	if err != nil {
+		gameroom.Reject(w, r, websocket.ClosePolicyViolation, err)
+		return
+	}
+	room.ConnectToGame(userId, w, r)
+}
+
+func matchmakeHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := accounts.Authenticate(r)
+	if err != nil {
+		gameroom.Reject(w, r, websocket.ClosePolicyViolation, err)
+		return
+	}
+	matchmaker.Serve(userId, w, r)
+}
+
+// matchQueue puts Connect4 players in a queue per rated variant and time control. Other games have a single
+// queue each.
+func matchQueue(playerId string, req *matchmaking.Request) (string, float64, error) {
+	if req.Game != "connect4" {
+		game, err := games.New(req.Game, req.Options)
+		if err != nil {
+			return "", 0, err
+		}
+		game.Close()
+		return req.Game, ratings.Initial, nil
+	}
+	options, err := ctypes.ParseOptions(req.Options)
+	if err != nil {
+		return "", 0, err
+	}
+	// Matched games always start from an empty board, and since who joins the room first is down to
+	// latency, colors are drawn at random.
+	options.RandomColors = true
+	req.Options, err = json.Marshal(options)
+	if err != nil {
+		return "", 0, err
+	}
+	// Time controls and series don't change the rating, but players only meet others who want the same.
+	gameType := storage.RatingType(options)
+	queue := gameType + " " + options.TimeControl.String()
+	if options.BestOf > 1 {
+		queue += fmt.Sprintf(" best of %d", options.BestOf)
+	}
+	rating, err := store.PlayerRating(playerId, gameType)
+	return queue, rating, err
+}
+
+func createMatchRoom(game string, options []byte, playerIds []string) (string, error) {
+	room, err := rooms.CreateReservedRoom(game, options, playerIds)
+	if err != nil {
+		return "", err
+	}
+	return room.Id, nil
+}
+
+// registerHandler creates an account. Bot accounts are given an API key straight away, since that's
+// what they'll connect with.
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req := &accountRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, "Malformed Account Request", http.StatusBadRequest)
+		return
+	}
+	err = accounts.Register(req.Id, req.Password, req.Bot)
+	if err == storage.ErrAccountExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := &accountResponse{Id: req.Id}
+	if req.Bot {
+		resp.APIKey, err = accounts.NewAPIKey(req.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJson(w, resp)
+}
+
+// loginHandler sets the session cookie and also returns the token for clients that would rather send it
+// as a bearer token. GET reports who the current session belongs to.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userId, err := accounts.Authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		writeJson(w, &accountResponse{Id: userId})
+	case http.MethodPost:
+		req := &accountRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			http.Error(w, "Malformed Login Request", http.StatusBadRequest)
+			return
+		}
+		token, err := accounts.Login(req.Id, req.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		auth.SetSession(w, token)
+		writeJson(w, &accountResponse{Id: req.Id, Token: token})
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	auth.ClearSession(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiKeysHandler issues a new API key for the logged in account.
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId, err := accounts.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	key, err := accounts.NewAPIKey(userId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, &accountResponse{Id: userId, APIKey: key})
+}
+
+func roomsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, rooms.OpenRooms())
+	case http.MethodPost:
+		// Only players with an account can open rooms, so rooms can't be created anonymously in bulk.
+		if _, err := accounts.Authenticate(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		req := &createRoomRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			http.Error(w, "Malformed Room Request", http.StatusBadRequest)
+			return
+		}
+		room, err := rooms.CreateRoom(req.Game, req.Options)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJson(w, lobby.RoomInfo{Id: room.Id, Game: req.Game})
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func gameTypesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, games.Names())
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
-	if userid, ok := query["userId"]; ok {
-		game.ConnectToGame(userid[0], w, r)
-	} else {
-		http.Error(w, "No User Id", http.StatusForbidden)
+	playerId := query.Get("playerId")
+	if playerId == "" {
+		http.Error(w, "No Player Id", http.StatusBadRequest)
+		return
+	}
+	limit := 20
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	history, err := store.PlayerHistory(playerId, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, history)
+}
+
+// leaderboardHandler lists the best rated players of one game type, by default standard Connect4.
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	gameType := query.Get("game")
+	if gameType == "" {
+		gameType = storage.RatingType(ctypes.DefaultOptions())
+	}
+	limit := 20
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	board, err := store.Leaderboard(gameType, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, board)
+}
+
+func ratingsHandler(w http.ResponseWriter, r *http.Request) {
+	playerId := r.URL.Query().Get("playerId")
+	if playerId == "" {
+		http.Error(w, "No Player Id", http.StatusBadRequest)
+		return
+	}
+	playerRatings, err := store.PlayerRatings(playerId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, playerRatings)
+}
+
+type replayResponse struct {
+	Game    *storage.StoredGame
+	Updates []json.RawMessage
+}
+
+// replayHandler serves /replay/{gameId}: a WebSocket viewer if the request is an upgrade, otherwise the
+// game and all of its updates as JSON.
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	gameId, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/replay/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Malformed Game Id", http.StatusBadRequest)
+		return
+	}
+	game, err := store.Game(gameId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	updates, err := store.Updates(gameId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	if websocket.IsWebSocketUpgrade(r) {
+		rp, err := replay.NewReplay(updates)
+		if err != nil {
+			gameroom.Reject(w, r, gameroom.CloseNoReplay, err)
+			return
+		}
+		rp.Serve(w, r)
+		return
+	}
+	resp := &replayResponse{
+		Game:    game,
+		Updates: []json.RawMessage{},
+	}
+	for _, update := range updates {
+		resp.Updates = append(resp.Updates, json.RawMessage(update))
+	}
+	writeJson(w, resp)
+}
+
+// recordRoom stores every new room, and every Connect4 game played in it once it finishes.
+func recordRoom(roomId, gameType string, game types.Game) {
+	err := store.RecordRoom(roomId, gameType)
+	if err != nil {
+		fmt.Println("FAILED TO RECORD ROOM:", err.Error())
+	}
+	if c4, ok := game.(*connect4.Connect4); ok {
+		c4.AddFinishHook(func(record *ctypes.GameRecord) {
+			_, err := store.RecordGame(roomId, record)
+			if err != nil {
+				fmt.Println("FAILED TO RECORD GAME:", err.Error())
+			}
+		})
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
 }
 
 func main() {
-	game, _ = gameroom.NewGameRoom(connect4.NewConnect4())
+	dbPath := flag.String("db", "gameroom.db", "SQLite database that finished games are stored in")
+	emptyTimeout := flag.Duration("empty-timeout", 10*time.Minute, "How long a room can have nobody connected before it's closed")
+	secret := flag.String("secret", "", "Key session tokens are signed with. If empty a random one is used and logins don't survive a restart")
+	flag.Parse()
+
+	var err error
+	store, err = storage.Open(*dbPath)
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close()
+
+	key := []byte(*secret)
+	if len(key) == 0 {
+		key, err = auth.NewSecret()
+		if err != nil {
+			panic(err)
+		}
+	}
+	accounts = auth.NewAuth(store, key)
+
+	rooms = lobby.NewLobby(30*time.Second, *emptyTimeout)
+	rooms.AddRoomHook(recordRoom)
+	matchmaker = matchmaking.NewMatchmaker(matchQueue, createMatchRoom)
 	fs := http.FileServer(http.Dir("./static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
-	http.HandleFunc("/game", gameConnect)
+	http.HandleFunc("/rooms", roomsHandler)
+	http.HandleFunc("/games", gameTypesHandler)
+	http.HandleFunc("/game/", gameConnect)
+	http.HandleFunc("/matchmake", matchmakeHandler)
+	http.HandleFunc("/register", registerHandler)
+	http.HandleFunc("/login", loginHandler)
+	http.HandleFunc("/logout", logoutHandler)
+	http.HandleFunc("/apikeys", apiKeysHandler)
+	http.HandleFunc("/history", historyHandler)
+	http.HandleFunc("/leaderboard", leaderboardHandler)
+	http.HandleFunc("/ratings", ratingsHandler)
+	http.HandleFunc("/replay/", replayHandler)
 	http.ListenAndServe(":8080", nil)
 }